@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Key reuse analysis: what can be forged from N signatures by the same key.
+
+// Every Lamport signature reveals one of the two preimages for each bit.
+// After a few signatures from the same key, some bits have both preimages
+// revealed (so either bit value can be signed), some have only one (so that
+// bit of the message is stuck), and with bad luck some have none (only
+// possible if there are no signatures at all).  A message can be forged if
+// every one of its bits lands on a revealed preimage.
+
+// For a random message, each bit with only one preimage revealed has a 1/2
+// chance of matching, and bits with both revealed always match, so the chance
+// a random message is forgeable is exactly 2^-(number of stuck bits).  With
+// the 4 signatures in signatures.go there are 31 stuck bits, so it takes
+// about 2 billion tries.
+
+// ForgeryAnalysis holds everything revealed by a set of signatures from one
+// key.
+type ForgeryAnalysis struct {
+	Pub        PublicKey
+	Signatures int
+
+	// Known has every revealed preimage, and zero blocks everywhere else.
+	Known        SecretKey
+	ZeroRevealed [256]bool
+	OneRevealed  [256]bool
+
+	// bitmasks of which preimages are known, in message bit order, so that
+	// CanSign() is just a few byte operations
+	zeroMask Block
+	oneMask  Block
+}
+
+// AnalyzeSignatures collects the preimages revealed by the signatures, where
+// sigs[i] is a signature on msgs[i].  Returns an error if any signature
+// doesn't match its message and the public key.
+func AnalyzeSignatures(pub PublicKey, msgs []Message, sigs []Signature) (*ForgeryAnalysis, error) {
+	if len(msgs) != len(sigs) {
+		return nil, fmt.Errorf("got %d messages and %d signatures", len(msgs), len(sigs))
+	}
+	if !pub.Suite.Valid() {
+		return nil, fmt.Errorf("invalid hash suite %d", uint8(pub.Suite))
+	}
+
+	a := &ForgeryAnalysis{Pub: pub, Signatures: len(sigs)}
+	a.Known.Suite = pub.Suite
+
+	for n, sig := range sigs {
+		if sig.Suite != pub.Suite {
+			return nil, fmt.Errorf("signature %d uses %s, pubkey uses %s",
+				n, sig.Suite, pub.Suite)
+		}
+		for i, block := range sig.Preimage {
+			if msgs[n][i/8]>>(7-(i%8))&0x01 == 0x01 {
+				if block.HashWith(pub.Suite) != pub.OneHash[i] {
+					return nil, fmt.Errorf(
+						"signature %d block %d doesn't match its message", n, i)
+				}
+				a.Known.OnePre[i] = block
+				a.OneRevealed[i] = true
+				a.oneMask[i/8] |= 0x80 >> uint(i%8)
+			} else {
+				if block.HashWith(pub.Suite) != pub.ZeroHash[i] {
+					return nil, fmt.Errorf(
+						"signature %d block %d doesn't match its message", n, i)
+				}
+				a.Known.ZeroPre[i] = block
+				a.ZeroRevealed[i] = true
+				a.zeroMask[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+	}
+	return a, nil
+}
+
+// Counts returns how many bits have both preimages revealed, only one, and
+// none.  They add up to 256.
+func (self *ForgeryAnalysis) Counts() (both, one, none int) {
+	for i := 0; i < 256; i++ {
+		switch {
+		case self.ZeroRevealed[i] && self.OneRevealed[i]:
+			both++
+		case self.ZeroRevealed[i] || self.OneRevealed[i]:
+			one++
+		default:
+			none++
+		}
+	}
+	return both, one, none
+}
+
+// Log2Probability returns log2 of the chance that a random message can be
+// forged.  This is exact: minus the number of bits with only one preimage
+// revealed, or -Inf if some bit has none.
+func (self *ForgeryAnalysis) Log2Probability() float64 {
+	_, one, none := self.Counts()
+	if none > 0 {
+		return math.Inf(-1)
+	}
+	return float64(-one)
+}
+
+// Probability returns the chance that a random message can be forged.
+// Powers of 2 are exact in a float64, so this is too.
+func (self *ForgeryAnalysis) Probability() float64 {
+	return math.Exp2(self.Log2Probability())
+}
+
+// ExpectedWork returns how many random messages you'd expect to hash before
+// finding one that can be forged.  +Inf if it can't be done.
+func (self *ForgeryAnalysis) ExpectedWork() float64 {
+	return math.Exp2(-self.Log2Probability())
+}
+
+// String summarizes the analysis.
+func (self *ForgeryAnalysis) String() string {
+	both, one, none := self.Counts()
+	s := fmt.Sprintf("%d signatures: %d bits both revealed, %d one revealed, %d none\n",
+		self.Signatures, both, one, none)
+	if none > 0 {
+		return s + "forgery impossible\n"
+	}
+	return s + fmt.Sprintf("forgery probability 2^%.0f, expected work %.4g hashes\n",
+		self.Log2Probability(), self.ExpectedWork())
+}
+
+// CanSign returns true if every bit of the message has its preimage revealed.
+func (self *ForgeryAnalysis) CanSign(msg Message) bool {
+	for i := range msg {
+		// 1 bits need the one preimage, 0 bits need the zero preimage
+		if msg[i]&^self.oneMask[i] != 0 || ^msg[i]&^self.zeroMask[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ForgeSignature puts together a signature on msg from the revealed
+// preimages.  Returns an error if CanSign(msg) is false.
+func (self *ForgeryAnalysis) ForgeSignature(msg Message) (Signature, error) {
+	if !self.CanSign(msg) {
+		return Signature{}, fmt.Errorf("message %x can't be signed with what's revealed", msg[:])
+	}
+	return Sign(msg, self.Known), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestAnalyzeProvided checks the analysis of the 4 signatures in
+// signatures.go against the numbers worked out by hand.
+func TestAnalyzeProvided(t *testing.T) {
+	pub, err := HexToPubkey(hexPubkey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	var sigs []Signature
+	for i, h := range []string{hexSignature1, hexSignature2, hexSignature3, hexSignature4} {
+		sig, err := HexToSignature(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, GetMessageFromString(fmt.Sprintf("%d", i+1)))
+		sigs = append(sigs, sig)
+	}
+
+	a, err := AnalyzeSignatures(pub, msgs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, one, none := a.Counts()
+	if both+one+none != 256 || one != 31 || none != 0 {
+		t.Fatalf("got counts %d %d %d, expect 225 31 0", both, one, none)
+	}
+	if a.ExpectedWork() != 1<<31 {
+		t.Fatalf("expected work %g, expect 2^31", a.ExpectedWork())
+	}
+	for _, msg := range msgs {
+		if !a.CanSign(msg) {
+			t.Fatalf("can't sign a message that was already signed")
+		}
+	}
+
+	// wrong message for a signature
+	msgs[0], msgs[1] = msgs[1], msgs[0]
+	if _, err = AnalyzeSignatures(pub, msgs, sigs); err == nil {
+		t.Fatalf("AnalyzeSignatures accepted signatures on the wrong messages")
+	}
+}
+
+// TestAnalyzeSearch signs enough messages with one key that forging is quick,
+// and runs the search with a template.
+func TestAnalyzeSearch(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no signatures: nothing revealed, nothing forgeable
+	a, err := AnalyzeSignatures(pub, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Probability() != 0 {
+		t.Fatalf("probability %g with no signatures, expect 0", a.Probability())
+	}
+	_, _, err = a.Search(context.Background(), CounterTemplate{Prefix: "forge "}, SearchOptions{})
+	if err == nil {
+		t.Fatalf("Search with no signatures should fail")
+	}
+
+	// 10 signatures leave about half a bit stuck on average
+	var msgs []Message
+	var sigs []Signature
+	for i := 0; i < 10; i++ {
+		msg := GetMessageFromString(fmt.Sprintf("reused %d", i))
+		msgs = append(msgs, msg)
+		sigs = append(sigs, Sign(msg, sec))
+	}
+	a, err = AnalyzeSignatures(pub, msgs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(strings.TrimSpace(a.String()))
+
+	tmpl := CounterTemplate{Prefix: "forge #", Suffix: " by the test"}
+	msgString, sig, err := a.Search(context.Background(), tmpl, SearchOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msgString, "forge #") || !strings.HasSuffix(msgString, " by the test") {
+		t.Fatalf("forged message %q doesn't fit the template", msgString)
+	}
+	if !Verify(GetMessageFromString(msgString), pub, sig) {
+		t.Fatalf("forged signature on %q doesn't verify", msgString)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// BLAKE2s-256, unkeyed, as in RFC 7693.
+
+// The standard library has sha256 and sha3 but not BLAKE2, and this pset
+// sticks to the standard library, so here's a small implementation.  It's a
+// straightforward transcription of the RFC and is not particularly fast.
+
+const (
+	blake2sBlockSize = 64
+	blake2sSize      = 32
+)
+
+var blake2sIV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var blake2sSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2sDigest implements hash.Hash.
+type blake2sDigest struct {
+	h   [8]uint32
+	t   uint64 // bytes compressed so far
+	buf [blake2sBlockSize]byte
+	n   int // bytes in buf
+}
+
+// newBLAKE2s256 returns a new BLAKE2s hash with a 32 byte output.
+func newBLAKE2s256() hash.Hash {
+	d := new(blake2sDigest)
+	d.Reset()
+	return d
+}
+
+func (self *blake2sDigest) Size() int      { return blake2sSize }
+func (self *blake2sDigest) BlockSize() int { return blake2sBlockSize }
+
+func (self *blake2sDigest) Reset() {
+	self.h = blake2sIV
+	// parameter block: 32 byte digest, no key, fanout 1, depth 1
+	self.h[0] ^= 0x01010000 | blake2sSize
+	self.t = 0
+	self.n = 0
+}
+
+// Write never returns an error.  The last block has to be compressed with the
+// final flag set, so a full buffer is only compressed once more data shows up.
+func (self *blake2sDigest) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if self.n == blake2sBlockSize {
+			self.t += blake2sBlockSize
+			blake2sCompress(&self.h, &self.buf, self.t, false)
+			self.n = 0
+		}
+		c := copy(self.buf[self.n:], p)
+		self.n += c
+		p = p[c:]
+	}
+	return written, nil
+}
+
+// Sum appends the hash to b without changing the state.
+func (self *blake2sDigest) Sum(b []byte) []byte {
+	d := *self
+	for i := d.n; i < blake2sBlockSize; i++ {
+		d.buf[i] = 0
+	}
+	d.t += uint64(d.n)
+	blake2sCompress(&d.h, &d.buf, d.t, true)
+
+	var out [blake2sSize]byte
+	for i, v := range d.h {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return append(b, out[:]...)
+}
+
+// The state can be saved and restored with MarshalBinary and UnmarshalBinary,
+// like the standard library hashes, so a hash of a common prefix can be
+// reused.  The format is a magic string, the 8 state words, the byte count,
+// the buffer, and how much of the buffer is in use.
+const (
+	blake2sMagic         = "b2s\x01"
+	blake2sMarshaledSize = len(blake2sMagic) + 8*4 + 8 + blake2sBlockSize + 1
+)
+
+// MarshalBinary saves the hash state.
+func (self *blake2sDigest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, blake2sMarshaledSize)
+	b = append(b, blake2sMagic...)
+	for _, v := range self.h {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	b = binary.BigEndian.AppendUint64(b, self.t)
+	b = append(b, self.buf[:]...)
+	return append(b, byte(self.n)), nil
+}
+
+// UnmarshalBinary restores a state saved by MarshalBinary.
+func (self *blake2sDigest) UnmarshalBinary(b []byte) error {
+	if len(b) != blake2sMarshaledSize || string(b[:len(blake2sMagic)]) != blake2sMagic {
+		return errors.New("blake2s: invalid hash state")
+	}
+	b = b[len(blake2sMagic):]
+	for i := range self.h {
+		self.h[i] = binary.BigEndian.Uint32(b[4*i:])
+	}
+	b = b[8*4:]
+	self.t = binary.BigEndian.Uint64(b)
+	copy(self.buf[:], b[8:])
+	n := int(b[8+blake2sBlockSize])
+	if n > blake2sBlockSize {
+		return errors.New("blake2s: invalid hash state")
+	}
+	self.n = n
+	return nil
+}
+
+// blake2sCompress mixes one block into the state.  t is the total number of
+// bytes hashed including this block.
+func blake2sCompress(h *[8]uint32, block *[blake2sBlockSize]byte, t uint64, last bool) {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+
+	var v [16]uint32
+	copy(v[:8], h[:])
+	copy(v[8:], blake2sIV[:])
+	v[12] ^= uint32(t)
+	v[13] ^= uint32(t >> 32)
+	if last {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint32) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft32(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -12)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft32(v[d]^v[a], -8)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -7)
+	}
+
+	for _, s := range blake2sSigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
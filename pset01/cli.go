@@ -0,0 +1,413 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Command line tool: keygen, sign and verify over files.
+
+// Running pset01 with no arguments does the assignment demo.  With arguments
+// it's a tool for using Lamport signatures on real files:
+//
+//   pset01 keygen -out key [-hash sha256] [-armor]
+//       writes the secret key to key and the public key to key.pub
+//   pset01 sign -key key [-state file] [-out sig] [-armor] [file]
+//       signs the file (or stdin) and writes the signature (to stdout)
+//   pset01 verify -pub key.pub -sig sig [file]
+//   pset01 verify -signer name [-trust store] -sig sig [file]
+//       checks the signature on the file (or stdin), with either a public
+//       key file or a signer from the trust store
+//   pset01 fingerprint key.pub
+//       prints the key's fingerprint
+//   pset01 trust [-store store] add name key.pub | revoke name | list
+//       edits or lists the trust store (see trust.go)
+//
+// Files are hashed with the key's hash suite a piece at a time, so they can
+// be any size.  Keys and signatures are written as hex, one line each, or
+// armored with -armor (see encoding.go).  Either format can be read back.
+
+// sign keeps a KeyStore (see keystore.go) next to the key, so signing a
+// second, different file with the same key fails instead of giving away half
+// the rest of the key.
+
+// Exit codes, so that scripts can tell "bad signature" from "bad arguments".
+const (
+	ExitOK      = 0 // worked; for verify, signature is valid
+	ExitInvalid = 1 // verify: signature is not valid
+	ExitUsage   = 2 // bad command line
+	ExitError   = 3 // anything else: missing files, bad keys, key reuse...
+)
+
+// defaultTrustStore is the trust store used when none is given.
+const defaultTrustStore = "pset01.trust"
+
+const cliUsage = `usage:
+  pset01                                      run the pset demo
+  pset01 keygen -out key [-hash suite]        make a keypair
+  pset01 sign -key key [-out sig] [file]      sign a file (default stdin)
+  pset01 verify -pub pub -sig sig [file]      verify a file (default stdin)
+  pset01 verify -signer name -sig sig [file]  verify against the trust store
+  pset01 fingerprint pub                      print a key's fingerprint
+  pset01 trust add name pub | revoke name | list
+                                              edit the trust store
+keygen and sign take -armor to write armored text instead of hex.
+verify -signer and trust take -trust / -store to use a trust store other
+than ` + defaultTrustStore + `.
+`
+
+// RunCommand runs a subcommand with the given arguments and returns the exit
+// code.  Split out of main() so the tests can call it.
+func RunCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return ExitUsage
+	}
+
+	var err error
+	code := ExitError
+	switch args[0] {
+	case "keygen":
+		code, err = cmdKeygen(args[1:], stderr)
+	case "sign":
+		code, err = cmdSign(args[1:], stdin, stdout, stderr)
+	case "verify":
+		code, err = cmdVerify(args[1:], stdin, stdout, stderr)
+	case "fingerprint":
+		code, err = cmdFingerprint(args[1:], stdout)
+	case "trust":
+		code, err = cmdTrust(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return ExitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], cliUsage)
+		return ExitUsage
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", args[0], err.Error())
+	}
+	return code
+}
+
+// cmdKeygen makes a new keypair and writes it out.
+func cmdKeygen(args []string, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "file to write the secret key to; pubkey goes in <out>.pub")
+	suiteName := fs.String("hash", SuiteSHA256.String(), "hash suite: sha256, sha3-256 or blake2s-256")
+	armored := fs.Bool("armor", false, "write armored keys instead of hex")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitUsage, nil
+	}
+	if *out == "" || fs.NArg() != 0 {
+		return ExitUsage, fmt.Errorf("need -out and no other arguments")
+	}
+	suite, err := ParseHashSuite(*suiteName)
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	sec, pub, err := GenerateKeyWithSuite(suite)
+	if err != nil {
+		return ExitError, err
+	}
+
+	secString, pubString := sec.ToHex(), pub.ToHex()
+	if *armored {
+		secString, pubString = sec.Armor(), pub.Armor()
+	}
+
+	// O_EXCL so an existing key never gets overwritten
+	err = writeNewFile(*out, secString, 0600)
+	if err != nil {
+		return ExitError, err
+	}
+	err = writeNewFile(*out+".pub", pubString, 0644)
+	if err != nil {
+		return ExitError, err
+	}
+	return ExitOK, nil
+}
+
+// cmdSign signs a file with a key, refusing if the key already signed
+// something else.
+func cmdSign(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keyFile := fs.String("key", "", "secret key file from keygen")
+	stateFile := fs.String("state", "", "keystore file tracking used keys (default <key>.used)")
+	out := fs.String("out", "", "file to write the signature to (default stdout)")
+	armored := fs.Bool("armor", false, "write an armored signature instead of hex")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitUsage, nil
+	}
+	if *keyFile == "" || fs.NArg() > 1 {
+		return ExitUsage, fmt.Errorf("need -key and at most one file")
+	}
+	if *stateFile == "" {
+		*stateFile = *keyFile + ".used"
+	}
+
+	s, err := readTrimmed(*keyFile)
+	if err != nil {
+		return ExitError, err
+	}
+	sec, err := parseSecretKey(s)
+	if err != nil {
+		return ExitError, fmt.Errorf("%s: %s", *keyFile, err.Error())
+	}
+
+	msg, err := messageFromArgs(fs.Args(), sec.Suite, stdin)
+	if err != nil {
+		return ExitError, err
+	}
+
+	ks, err := OpenKeyStore(*stateFile)
+	if err != nil {
+		return ExitError, err
+	}
+	sig, err := ks.Sign(msg, sec)
+	closeErr := ks.Close()
+	if err != nil {
+		return ExitError, err
+	}
+	if closeErr != nil {
+		return ExitError, closeErr
+	}
+
+	sigString := sig.ToHex() + "\n"
+	if *armored {
+		sigString = sig.Armor()
+	}
+	if *out == "" {
+		_, err = io.WriteString(stdout, sigString)
+	} else {
+		err = os.WriteFile(*out, []byte(sigString), 0644)
+	}
+	if err != nil {
+		return ExitError, err
+	}
+	return ExitOK, nil
+}
+
+// cmdVerify checks a signature on a file.  The key comes from -pub, or from
+// the trust store with -signer; with both, the key file has to be the
+// signer's key.  Unknown and revoked signers are errors, not invalid
+// signatures.
+func cmdVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pubFile := fs.String("pub", "", "public key file")
+	signer := fs.String("signer", "", "signer name to look up in the trust store")
+	trustFile := fs.String("trust", defaultTrustStore, "trust store file")
+	sigFile := fs.String("sig", "", "signature file")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitUsage, nil
+	}
+	if (*pubFile == "" && *signer == "") || *sigFile == "" || fs.NArg() > 1 {
+		return ExitUsage, fmt.Errorf("need -pub or -signer, -sig and at most one file")
+	}
+
+	var pub PublicKey
+	if *pubFile != "" {
+		s, err := readTrimmed(*pubFile)
+		if err != nil {
+			return ExitError, err
+		}
+		pub, err = parsePubkey(s)
+		if err != nil {
+			return ExitError, fmt.Errorf("%s: %s", *pubFile, err.Error())
+		}
+	}
+	if *signer != "" {
+		ts, err := LoadTrustStore(*trustFile)
+		if err != nil {
+			return ExitError, err
+		}
+		if *pubFile == "" {
+			pub, err = ts.PublicKey(*signer)
+			if err != nil {
+				return ExitError, err
+			}
+		} else {
+			e, err := ts.Lookup(*signer)
+			if err != nil {
+				return ExitError, err
+			}
+			if pub.Fingerprint() != e.Fingerprint {
+				return ExitError, fmt.Errorf("%s has fingerprint %s, but %s's key is %s",
+					*pubFile, pub.Fingerprint(), *signer, e.Fingerprint)
+			}
+		}
+	}
+
+	s, err := readTrimmed(*sigFile)
+	if err != nil {
+		return ExitError, err
+	}
+	sig, err := parseSignature(s)
+	if err != nil {
+		return ExitError, fmt.Errorf("%s: %s", *sigFile, err.Error())
+	}
+
+	msg, err := messageFromArgs(fs.Args(), pub.Suite, stdin)
+	if err != nil {
+		return ExitError, err
+	}
+
+	err = VerifyDetailed(msg, pub, sig)
+	if err != nil {
+		fmt.Fprintf(stdout, "signature INVALID: %s\n", err)
+		return ExitInvalid, nil
+	}
+	if *signer != "" {
+		fmt.Fprintf(stdout, "signature OK from %s (%s)\n", *signer, pub.Fingerprint())
+		return ExitOK, nil
+	}
+	fmt.Fprintln(stdout, "signature OK")
+	return ExitOK, nil
+}
+
+// cmdFingerprint prints the fingerprint of a public key file.
+func cmdFingerprint(args []string, stdout io.Writer) (int, error) {
+	if len(args) != 1 {
+		return ExitUsage, fmt.Errorf("need one public key file")
+	}
+	s, err := readTrimmed(args[0])
+	if err != nil {
+		return ExitError, err
+	}
+	pub, err := parsePubkey(s)
+	if err != nil {
+		return ExitError, fmt.Errorf("%s: %s", args[0], err.Error())
+	}
+	fmt.Fprintln(stdout, pub.Fingerprint())
+	return ExitOK, nil
+}
+
+// cmdTrust adds, revokes or lists signers in the trust store.
+func cmdTrust(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("trust", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	storeFile := fs.String("store", defaultTrustStore, "trust store file")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitUsage, nil
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return ExitUsage, fmt.Errorf("need add, revoke or list")
+	}
+
+	ts, err := LoadTrustStore(*storeFile)
+	if err != nil {
+		return ExitError, err
+	}
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		s, err := readTrimmed(args[2])
+		if err != nil {
+			return ExitError, err
+		}
+		pub, err := parsePubkey(s)
+		if err != nil {
+			return ExitError, fmt.Errorf("%s: %s", args[2], err.Error())
+		}
+		err = ts.Add(args[1], pub)
+		if err != nil {
+			return ExitError, err
+		}
+		fmt.Fprintf(stdout, "added %s %s\n", args[1], pub.Fingerprint())
+	case args[0] == "revoke" && len(args) == 2:
+		err = ts.Revoke(args[1])
+		if err != nil {
+			return ExitError, err
+		}
+	case args[0] == "list" && len(args) == 1:
+		for _, e := range ts.Entries() {
+			state := ""
+			if e.Revoked {
+				state = " revoked"
+			}
+			fmt.Fprintf(stdout, "%s %s%s\n", e.Name, e.Fingerprint, state)
+		}
+	default:
+		return ExitUsage, fmt.Errorf("expect add name pub, revoke name, or list")
+	}
+	return ExitOK, nil
+}
+
+// messageFromArgs hashes the file named in args, or stdin if there isn't one
+// or it's "-".
+func messageFromArgs(args []string, suite HashSuite, stdin io.Reader) (Message, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return suite.MessageFromReader(stdin)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return Message{}, err
+	}
+	defer f.Close()
+	return suite.MessageFromReader(f)
+}
+
+// isArmored says whether s looks like an armored block rather than hex.
+func isArmored(s string) bool {
+	return strings.HasPrefix(s, "-----BEGIN ")
+}
+
+// parsePubkey reads a public key in either hex or armor.
+func parsePubkey(s string) (PublicKey, error) {
+	if isArmored(s) {
+		return ArmorToPubkey(s)
+	}
+	return HexToPubkey(s)
+}
+
+// parseSecretKey reads a secret key in either hex or armor.
+func parseSecretKey(s string) (SecretKey, error) {
+	if isArmored(s) {
+		return ArmorToSecretKey(s)
+	}
+	return HexToSecretKey(s)
+}
+
+// parseSignature reads a signature in either hex or armor.
+func parseSignature(s string) (Signature, error) {
+	if isArmored(s) {
+		return ArmorToSignature(s)
+	}
+	return HexToSignature(s)
+}
+
+// readTrimmed reads a whole file and trims off whitespace, like the newline
+// at the end.
+func readTrimmed(filename string) (string, error) {
+	bts, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bts)), nil
+}
+
+// writeNewFile writes s and a newline to a file which must not exist yet.
+func writeNewFile(filename, s string, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(s + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
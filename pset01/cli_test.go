@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCLI goes through keygen, sign and verify, checking the exit codes for
+// a good signature, a changed file, key reuse, and bad arguments.
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	sig := filepath.Join(dir, "sig")
+	file := filepath.Join(dir, "release.tar")
+	other := filepath.Join(dir, "other.tar")
+	if err := os.WriteFile(file, []byte("release contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte("something else"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(stdin string, args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := RunCommand(args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	if code, out := run("", "keygen", "-out", key, "-hash", "sha3-256"); code != ExitOK {
+		t.Fatalf("keygen exit %d: %s", code, out)
+	}
+	// keygen won't overwrite a key
+	if code, _ := run("", "keygen", "-out", key); code != ExitError {
+		t.Fatalf("keygen over an existing key exit %d, expect %d", code, ExitError)
+	}
+
+	if code, out := run("", "sign", "-key", key, "-out", sig, file); code != ExitOK {
+		t.Fatalf("sign exit %d: %s", code, out)
+	}
+	if code, out := run("", "verify", "-pub", key+".pub", "-sig", sig, file); code != ExitOK {
+		t.Fatalf("verify exit %d: %s", code, out)
+	}
+	// same thing from stdin
+	code, out := run("release contents", "verify", "-pub", key+".pub", "-sig", sig)
+	if code != ExitOK {
+		t.Fatalf("verify from stdin exit %d: %s", code, out)
+	}
+
+	if code, _ := run("", "verify", "-pub", key+".pub", "-sig", sig, other); code != ExitInvalid {
+		t.Fatalf("verify of a different file exit %d, expect %d", code, ExitInvalid)
+	}
+
+	// signing the same file again is fine, a different one is not
+	if code, out := run("", "sign", "-key", key, file); code != ExitOK {
+		t.Fatalf("re-sign exit %d: %s", code, out)
+	}
+	code, out = run("", "sign", "-key", key, other)
+	if code != ExitError || !strings.Contains(out, ErrKeyReuse.Error()) {
+		t.Fatalf("sign with a used key exit %d: %s", code, out)
+	}
+
+	// armored keys and signatures work the same way
+	akey := filepath.Join(dir, "akey")
+	asig := filepath.Join(dir, "asig")
+	if code, out := run("", "keygen", "-armor", "-out", akey); code != ExitOK {
+		t.Fatalf("keygen -armor exit %d: %s", code, out)
+	}
+	if code, out := run("", "sign", "-armor", "-key", akey, "-out", asig, file); code != ExitOK {
+		t.Fatalf("sign -armor exit %d: %s", code, out)
+	}
+	if code, out := run("", "verify", "-pub", akey+".pub", "-sig", asig, file); code != ExitOK {
+		t.Fatalf("verify armored exit %d: %s", code, out)
+	}
+
+	if code, _ := run("", "verify", "-pub", key+".pub"); code != ExitUsage {
+		t.Fatalf("verify without -sig exit %d, expect %d", code, ExitUsage)
+	}
+	if code, _ := run("", "frobnicate"); code != ExitUsage {
+		t.Fatalf("unknown command exit %d, expect %d", code, ExitUsage)
+	}
+}
+
+// TestCLITrust verifies by signer name through the trust store, and checks
+// that unknown and revoked signers are refused.
+func TestCLITrust(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	sig := filepath.Join(dir, "sig")
+	store := filepath.Join(dir, "trust")
+	file := filepath.Join(dir, "release.tar")
+	if err := os.WriteFile(file, []byte("release contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := RunCommand(args, strings.NewReader(""), &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	if code, out := run("keygen", "-out", key); code != ExitOK {
+		t.Fatalf("keygen exit %d: %s", code, out)
+	}
+	if code, out := run("sign", "-key", key, "-out", sig, file); code != ExitOK {
+		t.Fatalf("sign exit %d: %s", code, out)
+	}
+	code, fp := run("fingerprint", key+".pub")
+	if code != ExitOK {
+		t.Fatalf("fingerprint exit %d: %s", code, fp)
+	}
+
+	if code, _ := run("verify", "-signer", "alice", "-trust", store, "-sig", sig, file); code != ExitError {
+		t.Fatalf("verify with unknown signer exit %d, expect %d", code, ExitError)
+	}
+	if code, out := run("trust", "-store", store, "add", "alice", key+".pub"); code != ExitOK {
+		t.Fatalf("trust add exit %d: %s", code, out)
+	}
+	code, out := run("trust", "-store", store, "list")
+	if code != ExitOK || out != "alice "+fp {
+		t.Fatalf("trust list exit %d: %s", code, out)
+	}
+	code, out = run("verify", "-signer", "alice", "-trust", store, "-sig", sig, file)
+	if code != ExitOK || !strings.Contains(out, strings.TrimSpace(fp)) {
+		t.Fatalf("verify -signer exit %d: %s", code, out)
+	}
+	// -pub and -signer together have to agree
+	code, out = run("verify", "-signer", "alice", "-trust", store, "-pub", key+".pub", "-sig", sig, file)
+	if code != ExitOK {
+		t.Fatalf("verify -signer -pub exit %d: %s", code, out)
+	}
+
+	if code, out := run("trust", "-store", store, "revoke", "alice"); code != ExitOK {
+		t.Fatalf("trust revoke exit %d: %s", code, out)
+	}
+	code, out = run("verify", "-signer", "alice", "-trust", store, "-sig", sig, file)
+	if code != ExitError || !strings.Contains(out, ErrRevokedSigner.Error()) {
+		t.Fatalf("verify with revoked signer exit %d: %s", code, out)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Compact public keys: a Lamport key identified by a single hash.
+
+// A PublicKey is 512 blocks, 16KB, which is a lot to hand around just to say
+// who you are.  But a signature reveals one preimage for every bit, and the
+// verifier hashes it to get that half of the public key anyway.  If the
+// signature also carries the other half (the 256 hashes for the rows the
+// message didn't use), the verifier can rebuild the whole public key from the
+// signature alone.  Then the public identity only needs to be something that
+// pins down the public key: PublicKey.Hash(), 32 bytes.
+
+// The signature goes from 8KB to 16KB, so this trades a smaller key for a
+// bigger signature.  That's a good trade for one-time keys, where every key
+// is only ever used with one signature anyway and the identity is what gets
+// published ahead of time.
+
+// A CompactSignature is a Lamport signature along with the hashes of the
+// preimages it didn't reveal.  Unrevealed[i] is from row 1 if bit i of the
+// message is 0, and row 0 if it's 1.
+type CompactSignature struct {
+	Sig        Signature
+	Unrevealed [256]Block
+}
+
+// SignCompact signs a message, and fills in the unrevealed hashes from the
+// secret key.
+func SignCompact(msg Message, sec SecretKey) CompactSignature {
+	var csig CompactSignature
+	csig.Sig = Sign(msg, sec)
+	for i := range csig.Unrevealed {
+		if msg[i/8]>>(7-(i%8))&0x01 == 0x01 {
+			csig.Unrevealed[i] = sec.ZeroPre[i].HashWith(sec.Suite)
+		} else {
+			csig.Unrevealed[i] = sec.OnePre[i].HashWith(sec.Suite)
+		}
+	}
+	return csig
+}
+
+// PublicKey rebuilds the public key the signature must have come from, if
+// it's a signature on msg.  Assumes the signature's suite is valid.
+func (self CompactSignature) PublicKey(msg Message) PublicKey {
+	pub := PublicKey{Suite: self.Sig.Suite}
+	for i, block := range self.Sig.Preimage {
+		revealed := block.HashWith(self.Sig.Suite)
+		if msg[i/8]>>(7-(i%8))&0x01 == 0x01 {
+			pub.OneHash[i], pub.ZeroHash[i] = revealed, self.Unrevealed[i]
+		} else {
+			pub.ZeroHash[i], pub.OneHash[i] = revealed, self.Unrevealed[i]
+		}
+	}
+	return pub
+}
+
+// VerifyCompact rebuilds the public key from the signature and checks that it
+// hashes to the identity.  id is PublicKey.Hash() of the signer's key.
+func VerifyCompact(msg Message, id Block, csig CompactSignature) bool {
+	if !csig.Sig.Suite.Valid() {
+		return false
+	}
+	return csig.PublicKey(msg).Hash() == id
+}
+
+// ToHex returns a hex string of a compact signature: the Lamport signature
+// (with its suite prefix, if any) followed by the 256 unrevealed hashes.
+func (self CompactSignature) ToHex() string {
+	return self.Sig.ToHex() + hex.EncodeToString(appendBlocks(nil, self.Unrevealed[:]))
+}
+
+// HexToCompactSignature takes a string from CompactSignature.ToHex() and
+// turns it into a compact signature.
+func HexToCompactSignature(s string) (CompactSignature, error) {
+	var csig CompactSignature
+
+	half := 256 * 64 // 256 blocks, 64 hex char per block
+	if len(s) < half {
+		return csig, fmt.Errorf(
+			"compact signature string %d characters, expect at least %d", len(s), 2*half)
+	}
+	sig, err := HexToSignature(s[:len(s)-half])
+	if err != nil {
+		return csig, err
+	}
+	csig.Sig = sig
+
+	bts, err := hex.DecodeString(s[len(s)-half:])
+	if err != nil {
+		return csig, err
+	}
+	readBlocks(csig.Unrevealed[:], bts)
+	return csig, nil
+}
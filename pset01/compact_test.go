@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestCompactGoodSig signs with a few suites and verifies against just the
+// 32 byte identity, and checks the rebuilt public key is the real one.
+func TestCompactGoodSig(t *testing.T) {
+	msg := GetMessageFromString("compact")
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3, SuiteBLAKE2s} {
+		sec, pub, err := GenerateKeyWithSuite(suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the message hash doesn't have to use the key's suite
+		csig := SignCompact(msg, sec)
+		if !VerifyCompact(msg, pub.Hash(), csig) {
+			t.Fatalf("%s: VerifyCompact returned false, expected true", suite)
+		}
+		if csig.PublicKey(msg) != pub {
+			t.Fatalf("%s: rebuilt public key doesn't match", suite)
+		}
+		// and the embedded signature is a normal one
+		if !Verify(msg, pub, csig.Sig) {
+			t.Fatalf("%s: Verify on the embedded signature returned false", suite)
+		}
+	}
+}
+
+// TestCompactBadSig tries a different message, a changed unrevealed hash and
+// a different identity.
+func TestCompactBadSig(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("bad")
+	csig := SignCompact(msg, sec)
+
+	if VerifyCompact(GetMessageFromString("worse"), pub.Hash(), csig) {
+		t.Fatalf("VerifyCompact returned true for a different message")
+	}
+	_, other, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyCompact(msg, other.Hash(), csig) {
+		t.Fatalf("VerifyCompact returned true for a different identity")
+	}
+	csig.Unrevealed[200][31] ^= 1
+	if VerifyCompact(msg, pub.Hash(), csig) {
+		t.Fatalf("VerifyCompact returned true for a changed unrevealed hash")
+	}
+}
+
+// TestCompactHex round trips a compact signature through hex, with and
+// without a suite prefix.
+func TestCompactHex(t *testing.T) {
+	msg := GetMessageFromString("hex")
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3} {
+		sec, pub, err := GenerateKeyWithSuite(suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csig := SignCompact(msg, sec)
+		back, err := HexToCompactSignature(csig.ToHex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back != csig || !VerifyCompact(msg, pub.Hash(), back) {
+			t.Fatalf("%s: compact signature changed in hex round trip", suite)
+		}
+	}
+	if _, err := HexToCompactSignature("abcd"); err == nil {
+		t.Fatalf("HexToCompactSignature should fail on a short string")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+)
+
+// Binary and armored encodings for keys and signatures.
+
+// Hex is easy to paste around but twice the size it needs to be, and doesn't
+// say what it is.  The binary encoding is a 3 byte header and then the
+// blocks, in the same order as the hex encoding:
+//
+//   version (1 byte, currently 1)
+//   type    (1 byte: 1 secret key, 2 public key, 3 signature)
+//   suite   (1 byte: 0 sha256, 1 sha3-256, 2 blake2s-256)
+//   blocks  (512 blocks for keys, 256 for signatures)
+//
+// The armored encoding is the binary encoding in a PEM block, so it can go
+// in text files and emails:
+//
+//   -----BEGIN LAMPORT PUBLIC KEY-----
+//   Hash: sha256
+//   Version: 1
+//
+//   AQIA3q2+7w...
+//   -----END LAMPORT PUBLIC KEY-----
+//
+// The headers are just for people to read; the binary header inside is what
+// gets checked.
+
+// encodingVersion is the only version we know how to read or write.
+const encodingVersion = 1
+
+// encodingType says what's in a binary encoding.
+type encodingType byte
+
+const (
+	typeSecretKey encodingType = 1
+	typePublicKey encodingType = 2
+	typeSignature encodingType = 3
+)
+
+// String gives the name of the type, which is also the PEM block type.
+func (self encodingType) String() string {
+	switch self {
+	case typeSecretKey:
+		return "LAMPORT SECRET KEY"
+	case typePublicKey:
+		return "LAMPORT PUBLIC KEY"
+	case typeSignature:
+		return "LAMPORT SIGNATURE"
+	}
+	return fmt.Sprintf("unknown type %d", byte(self))
+}
+
+// --- Blocks
+
+// BlockFromBytes is BlockFromByteSlice, but returns an error instead of
+// truncating or zero padding if the slice isn't exactly 32 bytes.
+func BlockFromBytes(by []byte) (Block, error) {
+	var bl Block
+	if len(by) != len(bl) {
+		return bl, fmt.Errorf("got %d bytes for a block, expect %d", len(by), len(bl))
+	}
+	copy(bl[:], by)
+	return bl, nil
+}
+
+// appendBlocks appends all the blocks to b, in order.
+func appendBlocks(b []byte, blocks []Block) []byte {
+	for _, bl := range blocks {
+		b = append(b, bl[:]...)
+	}
+	return b
+}
+
+// readBlocks fills dst from bts, which has to be exactly the right size.
+func readBlocks(dst []Block, bts []byte) error {
+	if len(bts) != 32*len(dst) {
+		return fmt.Errorf("got %d bytes, expect %d (%d blocks)",
+			len(bts), 32*len(dst), len(dst))
+	}
+	for i := range dst {
+		copy(dst[i][:], bts[32*i:])
+	}
+	return nil
+}
+
+// --- Binary
+
+// marshalBlocks puts the header in front of the blocks.
+func marshalBlocks(typ encodingType, suite HashSuite, blocks ...[]Block) []byte {
+	n := 3
+	for _, bls := range blocks {
+		n += 32 * len(bls)
+	}
+	b := make([]byte, 3, n)
+	b[0], b[1], b[2] = encodingVersion, byte(typ), byte(suite)
+	for _, bls := range blocks {
+		b = appendBlocks(b, bls)
+	}
+	return b
+}
+
+// unmarshalBlocks checks the header and fills in the blocks.
+func unmarshalBlocks(data []byte, typ encodingType, blocks ...[]Block) (HashSuite, error) {
+	if len(data) < 3 {
+		return 0, fmt.Errorf("%s: got %d bytes, too short for a header", typ, len(data))
+	}
+	if data[0] != encodingVersion {
+		return 0, fmt.Errorf("%s: version %d, can only read version %d",
+			typ, data[0], encodingVersion)
+	}
+	if encodingType(data[1]) != typ {
+		return 0, fmt.Errorf("expected %s, got %s", typ, encodingType(data[1]))
+	}
+	suite := HashSuite(data[2])
+	if !suite.Valid() {
+		return 0, fmt.Errorf("%s: %s is not a known hash suite", typ, suite)
+	}
+
+	expectedLength := 3
+	for _, bls := range blocks {
+		expectedLength += 32 * len(bls)
+	}
+	if len(data) != expectedLength {
+		return 0, fmt.Errorf("%s: got %d bytes, expect %d", typ, len(data), expectedLength)
+	}
+
+	data = data[3:]
+	for _, bls := range blocks {
+		readBlocks(bls, data[:32*len(bls)])
+		data = data[32*len(bls):]
+	}
+	return suite, nil
+}
+
+// MarshalBinary encodes the public key in the binary format.  Never fails.
+func (self PublicKey) MarshalBinary() ([]byte, error) {
+	return marshalBlocks(typePublicKey, self.Suite, self.ZeroHash[:], self.OneHash[:]), nil
+}
+
+// UnmarshalBinary decodes a public key from PublicKey.MarshalBinary().
+func (self *PublicKey) UnmarshalBinary(data []byte) error {
+	var p PublicKey
+	suite, err := unmarshalBlocks(data, typePublicKey, p.ZeroHash[:], p.OneHash[:])
+	if err != nil {
+		return err
+	}
+	p.Suite = suite
+	*self = p
+	return nil
+}
+
+// MarshalBinary encodes the secret key in the binary format.  Never fails.
+func (self SecretKey) MarshalBinary() ([]byte, error) {
+	return marshalBlocks(typeSecretKey, self.Suite, self.ZeroPre[:], self.OnePre[:]), nil
+}
+
+// UnmarshalBinary decodes a secret key from SecretKey.MarshalBinary().
+func (self *SecretKey) UnmarshalBinary(data []byte) error {
+	var sec SecretKey
+	suite, err := unmarshalBlocks(data, typeSecretKey, sec.ZeroPre[:], sec.OnePre[:])
+	if err != nil {
+		return err
+	}
+	sec.Suite = suite
+	*self = sec
+	return nil
+}
+
+// MarshalBinary encodes the signature in the binary format.  Never fails.
+func (self Signature) MarshalBinary() ([]byte, error) {
+	return marshalBlocks(typeSignature, self.Suite, self.Preimage[:]), nil
+}
+
+// UnmarshalBinary decodes a signature from Signature.MarshalBinary().
+func (self *Signature) UnmarshalBinary(data []byte) error {
+	var sig Signature
+	suite, err := unmarshalBlocks(data, typeSignature, sig.Preimage[:])
+	if err != nil {
+		return err
+	}
+	sig.Suite = suite
+	*self = sig
+	return nil
+}
+
+// --- Armor
+
+// armor wraps a binary encoding in a PEM block.
+func armor(data []byte) string {
+	blk := &pem.Block{
+		Type: encodingType(data[1]).String(),
+		Headers: map[string]string{
+			"Version": fmt.Sprintf("%d", data[0]),
+			"Hash":    HashSuite(data[2]).String(),
+		},
+		Bytes: data,
+	}
+	return string(pem.EncodeToMemory(blk))
+}
+
+// unarmor finds the PEM block in s and returns what's inside.  The PEM type
+// has to match, and there can't be anything but whitespace after it.
+func unarmor(s string, typ encodingType) ([]byte, error) {
+	blk, rest := pem.Decode([]byte(s))
+	if blk == nil {
+		return nil, fmt.Errorf("%s: no armored block found", typ)
+	}
+	if blk.Type != typ.String() {
+		return nil, fmt.Errorf("expected %s, got %s", typ, blk.Type)
+	}
+	for _, c := range rest {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return nil, fmt.Errorf("%s: trailing data after armored block", typ)
+		}
+	}
+	return blk.Bytes, nil
+}
+
+// Armor returns the public key as an armored text block.
+func (self PublicKey) Armor() string {
+	b, _ := self.MarshalBinary()
+	return armor(b)
+}
+
+// ArmorToPubkey decodes a public key from PublicKey.Armor().
+func ArmorToPubkey(s string) (PublicKey, error) {
+	var p PublicKey
+	b, err := unarmor(s, typePublicKey)
+	if err != nil {
+		return p, err
+	}
+	err = p.UnmarshalBinary(b)
+	return p, err
+}
+
+// Armor returns the secret key as an armored text block.
+func (self SecretKey) Armor() string {
+	b, _ := self.MarshalBinary()
+	return armor(b)
+}
+
+// ArmorToSecretKey decodes a secret key from SecretKey.Armor().
+func ArmorToSecretKey(s string) (SecretKey, error) {
+	var sec SecretKey
+	b, err := unarmor(s, typeSecretKey)
+	if err != nil {
+		return sec, err
+	}
+	err = sec.UnmarshalBinary(b)
+	return sec, err
+}
+
+// Armor returns the signature as an armored text block.
+func (self Signature) Armor() string {
+	b, _ := self.MarshalBinary()
+	return armor(b)
+}
+
+// ArmorToSignature decodes a signature from Signature.Armor().
+func ArmorToSignature(s string) (Signature, error) {
+	var sig Signature
+	b, err := unarmor(s, typeSignature)
+	if err != nil {
+		return sig, err
+	}
+	err = sig.UnmarshalBinary(b)
+	return sig, err
+}
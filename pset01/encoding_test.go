@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBinaryRoundTrip encodes and decodes keys and signatures in binary and
+// armor, and checks they still verify and keep their suite.
+func TestBinaryRoundTrip(t *testing.T) {
+	msg := SuiteBLAKE2s.MessageFromString("binary")
+	sec, pub, err := GenerateKeyWithSuite(SuiteBLAKE2s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Sign(msg, sec)
+
+	b, _ := pub.MarshalBinary()
+	if len(b) != 3+512*32 {
+		t.Fatalf("pubkey binary %d bytes, expect %d", len(b), 3+512*32)
+	}
+	var pub2 PublicKey
+	if err = pub2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = sec.MarshalBinary()
+	var sec2 SecretKey
+	if err = sec2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = sig.MarshalBinary()
+	var sig2 Signature
+	if err = sig2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if pub2 != pub || sec2 != sec || sig2 != sig {
+		t.Fatalf("binary round trip changed something")
+	}
+
+	pub3, err := ArmorToPubkey(pub.Armor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec3, err := ArmorToSecretKey(sec.Armor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig3, err := ArmorToSignature(sig.Armor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub3 != pub || sec3 != sec || sig3 != sig {
+		t.Fatalf("armor round trip changed something")
+	}
+	if !strings.Contains(pub.Armor(), "Hash: blake2s-256") {
+		t.Fatalf("armor missing Hash header:\n%s", pub.Armor())
+	}
+}
+
+// TestBinaryErrors checks that wrong types, versions and lengths are all
+// caught and say what went wrong.
+func TestBinaryErrors(t *testing.T) {
+	_, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, _ := pub.MarshalBinary()
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "too short"},
+		{"version", append([]byte{2}, good[1:]...), "version 2"},
+		{"type", append([]byte{1, 3}, good[2:]...), "expected LAMPORT PUBLIC KEY, got LAMPORT SIGNATURE"},
+		{"suite", append([]byte{1, 2, 9}, good[3:]...), "not a known hash suite"},
+		{"short", good[:len(good)-1], "expect 16387"},
+		{"long", append(good, 0), "expect 16387"},
+	}
+	for _, c := range cases {
+		var p PublicKey
+		err := p.UnmarshalBinary(c.data)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: got error %v, expect %q", c.name, err, c.want)
+		}
+	}
+
+	_, err = ArmorToSignature(pub.Armor())
+	if err == nil || !strings.Contains(err.Error(), "expected LAMPORT SIGNATURE") {
+		t.Fatalf("ArmorToSignature on a pubkey: got error %v", err)
+	}
+	if _, err = BlockFromBytes(make([]byte, 31)); err == nil {
+		t.Fatalf("BlockFromBytes accepted 31 bytes")
+	}
+}
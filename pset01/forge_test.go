@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -51,3 +54,32 @@ func TestForgery(t *testing.T) {
 	}
 
 }
+
+// TestForgeCheckpoint checks that forgeCheckpoint belongs to the key and
+// template Forge() uses, so forgeStart can't outlive a change to either, and
+// that the template is long enough for the search to reuse a midstate.
+func TestForgeCheckpoint(t *testing.T) {
+	pub, err := HexToPubkey(hexPubkey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err = os.WriteFile(path, []byte(forgeCheckpoint), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &ForgeryAnalysis{Pub: pub}
+	next, err := a.loadCheckpoint(path, forgeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != forgeStart {
+		t.Fatalf("checkpoint says %d, forgeStart is %d", next, forgeStart)
+	}
+
+	if len(forgeTemplate.Prefix) < sha256.BlockSize {
+		t.Fatalf("prefix is %d bytes, shorter than a block", len(forgeTemplate.Prefix))
+	}
+	if newCandidateHasher(SuiteSHA256, forgeTemplate).mid == nil {
+		t.Fatalf("candidate hasher isn't using a midstate for the forge template")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets for every decoder.  None of them should ever panic, and
+// anything a decoder accepts has to survive being encoded and decoded again
+// unchanged.  Run one with, for example,
+//   go test -fuzz FuzzHexToPubkey
+// Without -fuzz, the seeds below get run as ordinary tests.
+
+// fuzzSeedKey is a fixed key and signature to build seeds from, so the seed
+// corpus is the same every run.
+func fuzzSeedKey(suite HashSuite) (SecretKey, PublicKey, Signature) {
+	seed, _ := HexToBlock(katSeed)
+	sec, pub := DeriveKeyWithSuite(suite, seed, 0)
+	return sec, pub, Sign(GetMessageFromString("fuzz"), sec)
+}
+
+// fuzzDecoder is the common body of the string fuzz targets: if decode
+// accepts s, then decode(encode(v)) has to give v back.
+func fuzzDecoder[T any](f *testing.F, seeds []string,
+	decode func(string) (T, error), encode func(T) string) {
+
+	for _, s := range seeds {
+		f.Add(s)
+		// and a few broken versions of each
+		f.Add(s[:len(s)/2])
+		f.Add(strings.ToUpper(s))
+		f.Add(s + "0")
+	}
+	f.Add("")
+	f.Add("sha3-256:")
+	f.Add("not hex at all")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := decode(s)
+		if err != nil {
+			return
+		}
+		enc := encode(v)
+		v2, err := decode(enc)
+		if err != nil {
+			t.Fatalf("decoding the re-encoded value failed: %v", err)
+		}
+		if !reflect.DeepEqual(v, v2) {
+			t.Fatalf("value changed in round trip")
+		}
+	})
+}
+
+func FuzzHexToBlock(f *testing.F) {
+	_, pub, _ := fuzzSeedKey(SuiteSHA256)
+	fuzzDecoder(f, []string{pub.ZeroHash[0].ToHex()}, HexToBlock, Block.ToHex)
+}
+
+func FuzzHexToPubkey(f *testing.F) {
+	_, pub, _ := fuzzSeedKey(SuiteSHA256)
+	_, pub3, _ := fuzzSeedKey(SuiteSHA3)
+	fuzzDecoder(f, []string{pub.ToHex(), pub3.ToHex()}, HexToPubkey, PublicKey.ToHex)
+}
+
+func FuzzHexToSecretKey(f *testing.F) {
+	sec, _, _ := fuzzSeedKey(SuiteBLAKE2s)
+	fuzzDecoder(f, []string{sec.ToHex()}, HexToSecretKey, SecretKey.ToHex)
+}
+
+func FuzzHexToSignature(f *testing.F) {
+	_, _, sig := fuzzSeedKey(SuiteSHA256)
+	_, _, sig3 := fuzzSeedKey(SuiteSHA3)
+	fuzzDecoder(f, []string{sig.ToHex(), sig3.ToHex(), hexSignature1},
+		HexToSignature, Signature.ToHex)
+}
+
+func FuzzHexToWOTSPubkey(f *testing.F) {
+	sec, pub, err := GenerateWOTSKey(16)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sig, err := SignWOTS(GetMessageFromString("fuzz"), sec)
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, []string{pub.ToHex(), sig.ToHex()}, HexToWOTSPubkey, WOTSPublicKey.ToHex)
+}
+
+func FuzzHexToWOTSSignature(f *testing.F) {
+	sec, _, err := GenerateWOTSKey(256)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sig, err := SignWOTS(GetMessageFromString("fuzz"), sec)
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, []string{sig.ToHex()}, HexToWOTSSignature, WOTSSignature.ToHex)
+}
+
+func FuzzHexToMerkleSignature(f *testing.F) {
+	signer, _, err := GenerateMerkleKey(2)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sig, err := signer.Sign(GetMessageFromString("fuzz"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, []string{sig.ToHex()}, HexToMerkleSignature, MerkleSignature.ToHex)
+}
+
+func FuzzHexToCompactSignature(f *testing.F) {
+	sec, _, _ := fuzzSeedKey(SuiteSHA3)
+	csig := SignCompact(GetMessageFromString("fuzz"), sec)
+	fuzzDecoder(f, []string{csig.ToHex()}, HexToCompactSignature, CompactSignature.ToHex)
+}
+
+func FuzzHexToSaltedSignature(f *testing.F) {
+	sec, _, _ := fuzzSeedKey(SuiteSHA256)
+	ssig, err := SignBytes([]byte("fuzz"), "fuzz", sec)
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, []string{ssig.ToHex()}, HexToSaltedSignature, SaltedSignature.ToHex)
+}
+
+func FuzzHexToMultiKey(f *testing.F) {
+	_, pub, _ := fuzzSeedKey(SuiteSHA256)
+	_, pub3, _ := fuzzSeedKey(SuiteSHA3)
+	mk, err := NewMultiKey(1, []PublicKey{pub, pub3})
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, []string{mk.ToHex()}, HexToMultiKey, MultiKey.ToHex)
+}
+
+func FuzzHexToMultiSignature(f *testing.F) {
+	_, _, sig := fuzzSeedKey(SuiteSHA256)
+	_, _, sig3 := fuzzSeedKey(SuiteSHA3)
+	var msig MultiSignature
+	msig.Add(0, sig)
+	msig.Add(7, sig3)
+	fuzzDecoder(f, []string{msig.ToHex()}, HexToMultiSignature, MultiSignature.ToHex)
+}
+
+func FuzzArmor(f *testing.F) {
+	sec, pub, sig := fuzzSeedKey(SuiteBLAKE2s)
+	for _, s := range []string{pub.Armor(), sec.Armor(), sig.Armor()} {
+		f.Add(s)
+		f.Add(strings.Replace(s, "Version: 1", "Version: 2", 1))
+		f.Add(s[:len(s)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		if pub, err := ArmorToPubkey(s); err == nil {
+			if pub2, err := ArmorToPubkey(pub.Armor()); err != nil || pub2 != pub {
+				t.Fatalf("pubkey armor round trip: %v", err)
+			}
+		}
+		if sec, err := ArmorToSecretKey(s); err == nil {
+			if sec2, err := ArmorToSecretKey(sec.Armor()); err != nil || sec2 != sec {
+				t.Fatalf("secret key armor round trip: %v", err)
+			}
+		}
+		if sig, err := ArmorToSignature(s); err == nil {
+			if sig2, err := ArmorToSignature(sig.Armor()); err != nil || sig2 != sig {
+				t.Fatalf("signature armor round trip: %v", err)
+			}
+		}
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	sec, pub, sig := fuzzSeedKey(SuiteSHA3)
+	for _, m := range []interface{ MarshalBinary() ([]byte, error) }{pub, sec, sig} {
+		b, _ := m.MarshalBinary()
+		f.Add(b)
+		f.Add(b[:len(b)-1])
+		f.Add(append(bytes.Clone(b[:3]), 0xff))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var pub PublicKey
+		if pub.UnmarshalBinary(b) == nil {
+			enc, _ := pub.MarshalBinary()
+			if !bytes.Equal(enc, b) {
+				t.Fatalf("pubkey binary encoding isn't canonical")
+			}
+		}
+		var sec SecretKey
+		if sec.UnmarshalBinary(b) == nil {
+			enc, _ := sec.MarshalBinary()
+			if !bytes.Equal(enc, b) {
+				t.Fatalf("secret key binary encoding isn't canonical")
+			}
+		}
+		var sig Signature
+		if sig.UnmarshalBinary(b) == nil {
+			enc, _ := sig.MarshalBinary()
+			if !bytes.Equal(enc, b) {
+				t.Fatalf("signature binary encoding isn't canonical")
+			}
+		}
+	})
+}
+
+func FuzzParseHashSuite(f *testing.F) {
+	for _, s := range []string{"sha256", "sha3-256", "blake2s-256", "SHA256", "md5", ""} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		suite, err := ParseHashSuite(s)
+		if err != nil {
+			return
+		}
+		if !suite.Valid() || suite.String() != s {
+			t.Fatalf("ParseHashSuite(%q) gave %s", s, suite)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Hash suites: which 256 bit hash function a Lamport key uses.
+
+// Lamport signatures don't care which hash function they're built from, as
+// long as it's preimage resistant and has a 256 bit output.  The suite is
+// picked when the key is generated and carried along in the SecretKey,
+// PublicKey and Signature, so Verify() knows which function to use without
+// being told.
+
+// SuiteSHA256 is zero so that every key and signature made before suites
+// existed (and everything in signatures.go) is still sha256.
+
+// In hex, sha256 keys and signatures look exactly like they always have.
+// Other suites put the suite name and a colon in front, like
+//   sha3-256:0123abcd...
+
+// A HashSuite picks the hash function for a key.
+type HashSuite uint8
+
+const (
+	SuiteSHA256 HashSuite = iota
+	SuiteSHA3
+	SuiteBLAKE2s
+)
+
+// String gives the suite name used in hex encodings.
+func (self HashSuite) String() string {
+	switch self {
+	case SuiteSHA256:
+		return "sha256"
+	case SuiteSHA3:
+		return "sha3-256"
+	case SuiteBLAKE2s:
+		return "blake2s-256"
+	}
+	return fmt.Sprintf("suite(%d)", uint8(self))
+}
+
+// ParseHashSuite turns a suite name from String() back into a HashSuite.
+func ParseHashSuite(s string) (HashSuite, error) {
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3, SuiteBLAKE2s} {
+		if s == suite.String() {
+			return suite, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash suite %q", s)
+}
+
+// Valid returns true if the suite is one we know about.
+func (self HashSuite) Valid() bool {
+	return self <= SuiteBLAKE2s
+}
+
+// New returns a new hash.Hash for the suite, for hashing things that don't
+// fit in memory.  Returns nil for an invalid suite.
+func (self HashSuite) New() hash.Hash {
+	switch self {
+	case SuiteSHA256:
+		return sha256.New()
+	case SuiteSHA3:
+		return sha3.New256()
+	case SuiteBLAKE2s:
+		return newBLAKE2s256()
+	}
+	return nil
+}
+
+// Sum returns the hash of data.  Panics on an invalid suite, so check Valid()
+// on anything that came in from outside.
+func (self HashSuite) Sum(data []byte) Block {
+	switch self {
+	case SuiteSHA256:
+		return sha256.Sum256(data)
+	case SuiteSHA3:
+		return sha3.Sum256(data)
+	}
+	h := self.New()
+	if h == nil {
+		panic(fmt.Sprintf("invalid hash suite %d", uint8(self)))
+	}
+	h.Write(data)
+	return BlockFromByteSlice(h.Sum(nil))
+}
+
+// HashWith returns the hash of the block using the given suite.
+// Block.Hash() is the same as HashWith(SuiteSHA256).
+func (self Block) HashWith(suite HashSuite) Block {
+	return suite.Sum(self[:])
+}
+
+// MessageFromString is GetMessageFromString() for any suite.  A message to be
+// signed by a key should be hashed with the key's suite.
+func (self HashSuite) MessageFromString(s string) Message {
+	return Message(self.Sum([]byte(s)))
+}
+
+// MessageFromReader hashes everything from r into a message, a piece at a
+// time, so it works on files of any size.
+func (self HashSuite) MessageFromReader(r io.Reader) (Message, error) {
+	var msg Message
+	h := self.New()
+	if h == nil {
+		return msg, fmt.Errorf("invalid hash suite %d", uint8(self))
+	}
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return msg, err
+	}
+	copy(msg[:], h.Sum(nil))
+	return msg, nil
+}
+
+// suiteHexPrefix is what goes in front of a hex encoding for the suite.
+// Empty for sha256.
+func suiteHexPrefix(suite HashSuite) string {
+	if suite == SuiteSHA256 {
+		return ""
+	}
+	return suite.String() + ":"
+}
+
+// splitSuiteHex strips the suite name off the front of a hex encoding, if
+// there is one, and returns the suite and the rest of the string.
+func splitSuiteHex(s string) (HashSuite, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return SuiteSHA256, s, nil
+	}
+	suite, err := ParseHashSuite(s[:i])
+	if err != nil {
+		return 0, s, err
+	}
+	return suite, s[i+1:], nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestHashSuiteVectors checks every suite against known hashes of "abc", and
+// BLAKE2s against the empty string too since it's implemented here.
+func TestHashSuiteVectors(t *testing.T) {
+	vectors := []struct {
+		suite HashSuite
+		in    string
+		out   string
+	}{
+		{SuiteSHA256, "abc",
+			"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{SuiteSHA3, "abc",
+			"3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{SuiteBLAKE2s, "abc",
+			"508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		{SuiteBLAKE2s, "",
+			"69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+	}
+	for _, v := range vectors {
+		got := v.suite.MessageFromString(v.in)
+		if Block(got).ToHex() != v.out {
+			t.Fatalf("%s(%q) = %x, expect %s", v.suite, v.in, got, v.out)
+		}
+	}
+}
+
+// TestBLAKE2sStreaming hashes the same data in different sized pieces, which
+// should always give the same answer as hashing it all at once.  Lengths
+// around the 64 byte block size are the interesting ones.
+func TestBLAKE2sStreaming(t *testing.T) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for _, n := range []int{63, 64, 65, 128, 129, 200} {
+		want := SuiteBLAKE2s.Sum(data[:n])
+		for _, chunk := range []int{1, 7, 64} {
+			h := SuiteBLAKE2s.New()
+			for i := 0; i < n; i += chunk {
+				end := i + chunk
+				if end > n {
+					end = n
+				}
+				h.Write(data[i:end])
+			}
+			if !bytes.Equal(h.Sum(nil), want[:]) {
+				t.Fatalf("length %d in %d byte chunks gave a different hash", n, chunk)
+			}
+		}
+	}
+}
+
+// TestHashSuiteSig signs and verifies with every suite, and round trips
+// through hex, which has to remember the suite.
+func TestHashSuiteSig(t *testing.T) {
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3, SuiteBLAKE2s} {
+		msg := suite.MessageFromString("good")
+		sec, pub, err := GenerateKeyWithSuite(suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := Sign(msg, sec)
+
+		pub2, err := HexToPubkey(pub.ToHex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig2, err := HexToSignature(sig.ToHex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pub2.Suite != suite || sig2.Suite != suite {
+			t.Fatalf("%s: hex round trip lost the suite", suite)
+		}
+		if !Verify(msg, pub2, sig2) {
+			t.Fatalf("%s: Verify returned false, expected true", suite)
+		}
+
+		// the same blocks under a different suite don't verify
+		sig2.Suite = (suite + 1) % 3
+		if Verify(msg, pub2, sig2) {
+			t.Fatalf("%s: Verify accepted a signature from another suite", suite)
+		}
+	}
+
+	// sha256 hex has no prefix, so it's the same format as always
+	_, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.ToHex()) != 256*2*64 {
+		t.Fatalf("sha256 pubkey hex changed length")
+	}
+	if _, err = HexToPubkey("md5:" + pub.ToHex()); err == nil {
+		t.Fatalf("HexToPubkey accepted an unknown suite")
+	}
+}
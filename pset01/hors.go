@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+)
+
+// HORS and HORST few-time signatures.
+
+// Lamport falls apart after a couple of signatures (see Forge()).  HORS
+// ("hash to obtain random subset") is built to survive a few.  The secret key
+// is t random blocks and the public key is their hashes, like one row of a
+// Lamport key.  To sign, the message is cut into k numbers of log2(t) bits
+// each, and the preimages at those k positions are revealed.
+
+// After r signatures at most r*k of the t preimages are out.  A forger needs
+// a message whose k positions all land on revealed ones, and each position
+// does that with chance (revealed / t), so security goes down gradually as
+// more signatures are made instead of falling off a cliff.  SecurityBits()
+// puts a number on it.
+
+// HORST ("HORS with trees") is the same thing with a Merkle tree over the t
+// public key blocks, so the public key is a single root block.  The signature
+// gets bigger: each revealed preimage comes with its auth path.  This is the
+// few-time scheme at the bottom of SPHINCS.
+
+// Positions are read from the message MSB first, a = log2(t) bits at a time,
+// so k*a can't be more than 256.
+
+// MaxHORSLogT is the biggest log2(t) allowed.  A 2^16 key is 2MB, which is
+// already plenty.
+const MaxHORSLogT = 16
+
+// HORSParams are the k and t for a HORS or HORST key.  T must be a power of
+// 2.
+type HORSParams struct {
+	K int // preimages revealed per signature
+	T int // number of secret blocks
+}
+
+// DefaultHORSParams uses the whole message: 16 positions of 16 bits.
+var DefaultHORSParams = HORSParams{K: 16, T: 1 << 16}
+
+// --- Types
+
+// A HORSSecretKey is t random blocks.  HORS and HORST use the same secret key.
+type HORSSecretKey struct {
+	Params HORSParams
+	Pre    []Block
+}
+
+// A HORSPublicKey is the hash of every secret block.
+type HORSPublicKey struct {
+	Params HORSParams
+	Hash   []Block
+}
+
+// A HORSSignature is the k revealed preimages, in message order.
+type HORSSignature struct {
+	Params HORSParams
+	Pre    []Block
+}
+
+// A HORSTPublicKey is the root of a Merkle tree over the HORS public key.
+type HORSTPublicKey struct {
+	Params HORSParams
+	Root   Block
+}
+
+// A HORSTSignature is the k revealed preimages, each with the auth path from
+// its leaf to the root.
+type HORSTSignature struct {
+	Params HORSParams
+	Pre    []Block
+	Paths  [][]Block
+}
+
+// --- Parameters
+
+// logT returns log2(t), or an error if the parameters can't be used.
+func (self HORSParams) logT() (int, error) {
+	if self.T < 2 || self.T&(self.T-1) != 0 {
+		return 0, fmt.Errorf("HORS t=%d invalid, expect a power of 2", self.T)
+	}
+	a := 0
+	for 1<<uint(a) < self.T {
+		a++
+	}
+	if a > MaxHORSLogT {
+		return 0, fmt.Errorf("HORS t=%d too big, max 2^%d", self.T, MaxHORSLogT)
+	}
+	if self.K < 1 || self.K*a > 256 {
+		return 0, fmt.Errorf(
+			"HORS k=%d invalid for t=%d, expect 1 to %d", self.K, self.T, 256/a)
+	}
+	return a, nil
+}
+
+// Valid returns true if the parameters can be used.
+func (self HORSParams) Valid() bool {
+	_, err := self.logT()
+	return err == nil
+}
+
+// SecurityBits returns about how many bits of security are left against
+// forging a random message after r signatures with the same key.  After r*k
+// positions are revealed (some of them repeats), a given position has been
+// revealed with chance 1 - (1-1/t)^(r*k), and a forgery needs all k to be.
+// Capped at 256, which is what a single sha256 preimage is worth; with no
+// signatures at all, nothing has been revealed.  Assumes valid parameters.
+func (self HORSParams) SecurityBits(r int) float64 {
+	if r <= 0 {
+		return 256
+	}
+	t, k := float64(self.T), float64(self.K)
+	revealed := -math.Expm1(float64(r) * k * math.Log1p(-1/t))
+	return math.Min(256, -k*math.Log2(revealed))
+}
+
+// horsIndices returns the k positions to reveal for msg.  Assumes valid
+// parameters.
+func horsIndices(msg Message, params HORSParams) []int {
+	a, _ := params.logT()
+	idx := make([]int, params.K)
+	for i := range idx {
+		for j := 0; j < a; j++ {
+			bit := i*a + j
+			idx[i] = idx[i]<<1 | int(msg[bit/8]>>(7-(bit%8))&0x01)
+		}
+	}
+	return idx
+}
+
+// --- HORS
+
+// GenerateHORSKey makes a HORS keypair.  Like GenerateKey(), randomness
+// comes from crypto/rand.
+func GenerateHORSKey(params HORSParams) (HORSSecretKey, HORSPublicKey, error) {
+	sec := HORSSecretKey{Params: params}
+	pub := HORSPublicKey{Params: params}
+	if _, err := params.logT(); err != nil {
+		return sec, pub, err
+	}
+
+	sec.Pre = make([]Block, params.T)
+	for i := range sec.Pre {
+		_, err := rand.Read(sec.Pre[i][:])
+		if err != nil {
+			return sec, pub, err
+		}
+	}
+	return sec, sec.HORSPublicKey(), nil
+}
+
+// HORSPublicKey hashes every secret block to get the HORS public key.
+func (self HORSSecretKey) HORSPublicKey() HORSPublicKey {
+	pub := HORSPublicKey{Params: self.Params, Hash: make([]Block, len(self.Pre))}
+	for i, b := range self.Pre {
+		pub.Hash[i] = b.Hash()
+	}
+	return pub
+}
+
+// SignHORS reveals the preimages at the message's k positions.
+func SignHORS(msg Message, sec HORSSecretKey) HORSSignature {
+	sig := HORSSignature{Params: sec.Params}
+	for _, i := range horsIndices(msg, sec.Params) {
+		sig.Pre = append(sig.Pre, sec.Pre[i])
+	}
+	return sig
+}
+
+// VerifyHORS hashes each revealed preimage and checks it against the public
+// key at its position.
+func VerifyHORS(msg Message, pub HORSPublicKey, sig HORSSignature) bool {
+	if sig.Params != pub.Params || !pub.Params.Valid() {
+		return false
+	}
+	if len(pub.Hash) != pub.Params.T || len(sig.Pre) != pub.Params.K {
+		return false
+	}
+	for n, i := range horsIndices(msg, pub.Params) {
+		if sig.Pre[n].Hash() != pub.Hash[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// --- HORST
+
+// GenerateHORSTKey makes a HORS secret key and returns it with the root of
+// the tree over its public key.
+func GenerateHORSTKey(params HORSParams) (HORSSecretKey, HORSTPublicKey, error) {
+	sec, _, err := GenerateHORSKey(params)
+	if err != nil {
+		return sec, HORSTPublicKey{Params: params}, err
+	}
+	return sec, sec.HORSTPublicKey(), nil
+}
+
+// horstLevels builds the Merkle tree over the HORS public key.
+func (self HORSSecretKey) horstLevels() [][]Block {
+	return merkleLevels(self.HORSPublicKey().Hash)
+}
+
+// HORSTPublicKey returns the root of the tree over the HORS public key.
+func (self HORSSecretKey) HORSTPublicKey() HORSTPublicKey {
+	levels := self.horstLevels()
+	return HORSTPublicKey{Params: self.Params, Root: levels[len(levels)-1][0]}
+}
+
+// SignHORST reveals the preimages at the message's k positions, each with its
+// auth path.  The tree isn't kept around, so every signature rebuilds it:
+// about 2t hashes.
+func SignHORST(msg Message, sec HORSSecretKey) HORSTSignature {
+	sig := HORSTSignature{Params: sec.Params}
+	levels := sec.horstLevels()
+	for _, i := range horsIndices(msg, sec.Params) {
+		sig.Pre = append(sig.Pre, sec.Pre[i])
+		sig.Paths = append(sig.Paths, merkleAuthPath(levels, uint64(i)))
+	}
+	return sig
+}
+
+// VerifyHORST hashes each revealed preimage into its leaf, and checks that
+// every auth path leads to the root.
+func VerifyHORST(msg Message, pub HORSTPublicKey, sig HORSTSignature) bool {
+	if sig.Params != pub.Params {
+		return false
+	}
+	root, ok := horstRoot(msg, sig)
+	return ok && root == pub.Root
+}
+
+// horstRoot follows every auth path in the signature up to the root, and
+// returns the root if they all agree.  Returns false if the signature is
+// malformed or the paths lead to different roots.
+func horstRoot(msg Message, sig HORSTSignature) (Block, bool) {
+	var root Block
+	a, err := sig.Params.logT()
+	if err != nil {
+		return root, false
+	}
+	if len(sig.Pre) != sig.Params.K || len(sig.Paths) != sig.Params.K {
+		return root, false
+	}
+	for n, i := range horsIndices(msg, sig.Params) {
+		if len(sig.Paths[n]) != a {
+			return root, false
+		}
+		node := merkleRootFromPath(sig.Pre[n].Hash(), uint64(i), sig.Paths[n])
+		if n > 0 && node != root {
+			return root, false
+		}
+		root = node
+	}
+	return root, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestHORSGoodSig signs a few messages with the same key, which is the whole
+// point of HORS, and checks they all verify with both HORS and HORST.
+func TestHORSGoodSig(t *testing.T) {
+	for _, params := range []HORSParams{{K: 8, T: 256}, {K: 32, T: 128}, DefaultHORSParams} {
+		sec, pub, err := GenerateHORSKey(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tpub := sec.HORSTPublicKey()
+		for i := 0; i < 3; i++ {
+			msg := GetMessageFromString(fmt.Sprintf("few %d", i))
+			if !VerifyHORS(msg, pub, SignHORS(msg, sec)) {
+				t.Fatalf("%+v: VerifyHORS returned false, expected true", params)
+			}
+			if !VerifyHORST(msg, tpub, SignHORST(msg, sec)) {
+				t.Fatalf("%+v: VerifyHORST returned false, expected true", params)
+			}
+		}
+	}
+}
+
+// TestHORSBadSig tries signatures on the wrong message, with a changed
+// preimage, and with a changed auth path.
+func TestHORSBadSig(t *testing.T) {
+	params := HORSParams{K: 16, T: 1024}
+	sec, tpub, err := GenerateHORSTKey(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := sec.HORSPublicKey()
+	msg := GetMessageFromString("bad")
+	other := GetMessageFromString("worse")
+
+	sig := SignHORS(msg, sec)
+	if VerifyHORS(other, pub, sig) {
+		t.Fatalf("VerifyHORS returned true for a different message")
+	}
+	sig.Pre[5][0] ^= 1
+	if VerifyHORS(msg, pub, sig) {
+		t.Fatalf("VerifyHORS returned true for a changed preimage")
+	}
+
+	tsig := SignHORST(msg, sec)
+	if VerifyHORST(other, tpub, tsig) {
+		t.Fatalf("VerifyHORST returned true for a different message")
+	}
+	tsig.Paths[3][2][0] ^= 1
+	if VerifyHORST(msg, tpub, tsig) {
+		t.Fatalf("VerifyHORST returned true for a changed auth path")
+	}
+
+	// parameters have to match
+	tsig = SignHORST(msg, sec)
+	tsig.Params.K = 8
+	if VerifyHORST(msg, tpub, tsig) {
+		t.Fatalf("VerifyHORST returned true with mismatched parameters")
+	}
+}
+
+// TestHORSParams checks that bad parameters get rejected.
+func TestHORSParams(t *testing.T) {
+	bad := []HORSParams{
+		{K: 8, T: 100},     // not a power of 2
+		{K: 0, T: 256},     // no positions
+		{K: 33, T: 256},    // 33*8 bits is more than the message
+		{K: 1, T: 1 << 17}, // too big
+		{K: 4, T: 1},       // too small
+	}
+	for _, params := range bad {
+		if _, _, err := GenerateHORSKey(params); err == nil {
+			t.Fatalf("GenerateHORSKey(%+v) should fail", params)
+		}
+	}
+}
+
+// TestHORSSecurityBits checks that security goes down with every signature,
+// and is close to the usual k*(log2 t - log2 rk) estimate when few preimages
+// are out.
+func TestHORSSecurityBits(t *testing.T) {
+	params := DefaultHORSParams
+	if params.SecurityBits(0) != 256 {
+		t.Fatalf("security with no signatures %f, expect 256", params.SecurityBits(0))
+	}
+	prev := params.SecurityBits(0)
+	for r := 1; r <= 64; r++ {
+		bits := params.SecurityBits(r)
+		if bits >= prev {
+			t.Fatalf("security after %d signatures %f, not less than %f", r, bits, prev)
+		}
+		prev = bits
+		estimate := float64(params.K) * (16 - math.Log2(float64(r*params.K)))
+		if math.Abs(bits-estimate) > 1 {
+			t.Fatalf("security after %d signatures %f, expect about %f", r, bits, estimate)
+		}
+	}
+	t.Logf("k=%d t=%d: %.1f bits after 1 signature, %.1f after 64",
+		params.K, params.T, params.SecurityBits(1), params.SecurityBits(64))
+}
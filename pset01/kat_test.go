@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Known answer tests: keys derived from a fixed seed, and hashes of every
+// wire encoding of them.  If any of these change, keys and signatures saved
+// by an older version won't read back, so think hard before updating them.
+// The first secret block and its hash were checked against Python's hmac and
+// hashlib.
+
+// katSeed is 00 01 02 ... 1f.
+const katSeed = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// katVector is what DeriveKeyWithSuite(suite, katSeed, 0) should give, and a
+// signature from it on GetMessageFromString("known answer").  The encoding
+// fields are sha256 of the output of that encoding.
+type katVector struct {
+	suite    HashSuite
+	zeroPre  string // sec.ZeroPre[0]
+	zeroHash string // pub.ZeroHash[0]
+	pubHash  string // pub.Hash()
+	binHead  string // first 8 bytes of pub.MarshalBinary()
+
+	pubHex, secHex, sigHex string
+	pubBin, sigBin         string
+	pubArmor, sigArmor     string
+}
+
+var katVectors = []katVector{
+	{
+		suite:    SuiteSHA256,
+		zeroPre:  "48be9dfa703db3db2a62069750b03c18d368fac79bd8f37a87da75d908ad5a07",
+		zeroHash: "d5e503c73e744e3dd0e8bf8bf2c943413779946a2d748590e3488e0a5a8d1434",
+		pubHash:  "e7746c563d8d0d2eb158d6520cb8a7ae3de3d8dd8fb052f458c6ceec30c22bb2",
+		binHead:  "010200d5e503c73e",
+		pubHex:   "05a002524b81938a0b78e9db35dd7befe9615306a4a953da8f4f39b60b20bb04",
+		secHex:   "681029565d2c545cca599242767f46be06790dd5f56558509edc73c1e2d55ff4",
+		sigHex:   "95f4f82a6d5a58a84782c4a33d4a712c10694453e477bd63eaaa3131e401af97",
+		pubBin:   "801d81b21971f18a8000897e1a9c1843c1e4d3778adda018267ae279b7e03934",
+		sigBin:   "2c94cbb3b09de4d64f094b5560c285c017226c788ec67bb481a4e18ee5fd15dd",
+		pubArmor: "53f743fbd3fd6ba8592f50390b5634510240a6ae2b162f1a0c3f7abaed0d1f59",
+		sigArmor: "e8b647714cf0986c4c7f971da5644c09ebb06ee2607b3f14f2b8342724ed255c",
+	},
+	{
+		suite:    SuiteSHA3,
+		zeroPre:  "e42cb8888415ff1af2436676e8fc358976f711556492c6e55541cbeba8e28754",
+		zeroHash: "82986dc45c092424503a3e308262e48d3b2292873a37f760b1ad354787a5087e",
+		pubHash:  "21863be508dde38704764c1bfbd56faa05f2b3ab4e2e9f31a775887881c3df8e",
+		binHead:  "01020182986dc45c",
+		pubHex:   "0b04b66f2952b3199406783d5580bfcbaf282f728a1e496eb7aa16b6dd3b84fd",
+		secHex:   "a000781168daf6d8571695b662c4f6878a31ef61e6519eff116f8aa810ade0ff",
+		sigHex:   "67be2acce43f494325a3207c1c8bb306c1ff34f64697e1eb4df5f0d0a983a95b",
+		pubBin:   "8fac61233bb5c55a48d5b753336fd48971e88f3d3b0ddebc991b0979de9ed9c4",
+		sigBin:   "9165985871cc65e29dc706b2f11c71d3b13c8b04efbc12a3a4da7aa66757e368",
+		pubArmor: "c7a271f80736cc011dd3e784e5ecae18435b5c1cb214e5037bedbcdfd001a048",
+		sigArmor: "3e6527c4367e8d71c0bf0a3b028ca189476f2bf6f00fdede2fd6a1a32853bed4",
+	},
+	{
+		suite:    SuiteBLAKE2s,
+		zeroPre:  "9942d99af684bcc1b3f02ec4b45aebf73db928e66d83b2eca14d2c2e58183493",
+		zeroHash: "ecad4dc182bb15aba0326dbaeb44942f8fe5d48de73e244429b2e95bb0b61618",
+		pubHash:  "14fe52164d6e1adeb78415aae8c6d6559ed9e43e9cf4c16a771beac08f268695",
+		binHead:  "010202ecad4dc182",
+		pubHex:   "1a1fb586c858bc4e9bfd5bb9da8a9c57090be690ee44491feb44be18b4690f96",
+		secHex:   "e86af025a3a9198ce8ab4f6be2c15a379775a5040490b840fab8f38606ba3a09",
+		sigHex:   "5e113d2105aa443a7e87ba1ebd87d872eb45d0ff18c63758f820aea38ca74f2d",
+		pubBin:   "1a2b4ebb955f750778a5534c5f5d95804e73fdd479d32ef3c4cb5401b8216664",
+		sigBin:   "deb4008926e221434e55eab890a44dfaf90bccf1880812081c73d37d85e5b69c",
+		pubArmor: "96ea0b93f5d011a7570a78a0249c1627d418129e65168bca43e7582aa52b7097",
+		sigArmor: "001629e0f8430bfb0c72766ebe0eb5c5d21c1a1d062adab3e78c1bb98e78978f",
+	},
+}
+
+// TestKnownAnswers derives the keys and checks every vector.
+func TestKnownAnswers(t *testing.T) {
+	seed, err := HexToBlock(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+
+	for _, v := range katVectors {
+		sec, pub := DeriveKeyWithSuite(v.suite, seed, 0)
+		sig := Sign(GetMessageFromString("known answer"), sec)
+		pubBin, err := pub.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigBin, err := sig.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string][2]string{
+			"secret block":  {sec.ZeroPre[0].ToHex(), v.zeroPre},
+			"pubkey block":  {pub.ZeroHash[0].ToHex(), v.zeroHash},
+			"pubkey hash":   {pub.Hash().ToHex(), v.pubHash},
+			"binary header": {hex.EncodeToString(pubBin[:8]), v.binHead},
+			"pubkey hex":    {sum([]byte(pub.ToHex())), v.pubHex},
+			"secret hex":    {sum([]byte(sec.ToHex())), v.secHex},
+			"signature hex": {sum([]byte(sig.ToHex())), v.sigHex},
+			"pubkey binary": {sum(pubBin), v.pubBin},
+			"sig binary":    {sum(sigBin), v.sigBin},
+			"pubkey armor":  {sum([]byte(pub.Armor())), v.pubArmor},
+			"sig armor":     {sum([]byte(sig.Armor())), v.sigArmor},
+		}
+		for what, pair := range got {
+			if pair[0] != pair[1] {
+				t.Errorf("%s %s: got %s, expect %s", v.suite, what, pair[0], pair[1])
+			}
+		}
+	}
+}
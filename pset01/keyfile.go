@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Passphrase protected secret key files.
+
+// A secret key saved with SecretKey.ToHex() or Armor() is 16KB of preimages
+// sitting on disk for anyone who can read the file.  A key file instead
+// encrypts the secret key with AES-256-GCM, under a key derived from a
+// passphrase with scrypt.
+
+// The file is a PEM block.  Its headers are in the clear, so that anyone can
+// see which key it holds and whether it's been used without the passphrase:
+//   Version: 1
+//   Hash: sha256
+//   Fingerprint: <PublicKey.Hash() in hex>
+//   State: unused              (or "used")
+//   Message: <hex>             (only if used: the message it signed)
+//   KDF: scrypt
+//   Scrypt: N=32768,r=8,p=1
+//   Salt: <16 bytes hex>
+//   Nonce: <12 bytes hex>
+// and the body is the GCM ciphertext of SecretKey.MarshalBinary().  All of
+// the headers are authenticated as GCM additional data, so changing any of
+// them (say, setting State back to unused) makes the file fail to decrypt.
+// That means marking a key used needs the passphrase, but signing needs it
+// anyway.
+
+// Secret bytes (the derived key and the plaintext) are zeroed as soon as
+// they're done with.  Go can copy memory around behind our backs, so this is
+// about not leaving obvious copies lying around rather than a guarantee.
+
+const (
+	keyFileType    = "LAMPORT ENCRYPTED SECRET KEY"
+	keyFileVersion = "1"
+	keyFileAADTag  = "lamport key file\n"
+)
+
+// keyFileHeaders is the order headers go into the additional data.
+var keyFileHeaders = []string{
+	"Version", "Hash", "Fingerprint", "State", "Message", "KDF", "Scrypt", "Salt", "Nonce",
+}
+
+// ScryptParams are the scrypt cost parameters.  Memory use is 128*N*R bytes.
+type ScryptParams struct {
+	N, R, P int
+}
+
+// DefaultScryptParams takes 32MB and a fraction of a second.
+var DefaultScryptParams = ScryptParams{N: 1 << 15, R: 8, P: 1}
+
+// KeyFileInfo is what's in the clear part of a key file.
+type KeyFileInfo struct {
+	Suite       HashSuite
+	Fingerprint Block // PublicKey.Hash() of the key inside
+	Used        bool
+	Message     Message // what the key signed, if Used
+	Scrypt      ScryptParams
+}
+
+// Zero overwrites every preimage in the secret key.
+func (self *SecretKey) Zero() {
+	clear(self.ZeroPre[:])
+	clear(self.OnePre[:])
+}
+
+// keyFileAEAD derives the key from the passphrase and sets up AES-GCM.
+func keyFileAEAD(passphrase, salt []byte, params ScryptParams) (cipher.AEAD, error) {
+	key, err := scryptKey(passphrase, salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyFileAAD puts the headers together into the additional data.
+func keyFileAAD(headers map[string]string) []byte {
+	var b strings.Builder
+	b.WriteString(keyFileAADTag)
+	for _, name := range keyFileHeaders {
+		fmt.Fprintf(&b, "%s: %s\n", name, headers[name])
+	}
+	return []byte(b.String())
+}
+
+// SaveKeyFile encrypts the secret key with the passphrase and writes it to
+// path, replacing whatever was there.  The suite and fingerprint come from
+// the key; Used, Message and Scrypt come from info, with zero Scrypt meaning
+// DefaultScryptParams.  Every save uses a fresh salt and nonce.
+func SaveKeyFile(path string, sec *SecretKey, info KeyFileInfo, passphrase []byte) error {
+	params := info.Scrypt
+	if params == (ScryptParams{}) {
+		params = DefaultScryptParams
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := keyFileAEAD(passphrase, salt, params)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Version":     keyFileVersion,
+		"Hash":        sec.Suite.String(),
+		"Fingerprint": sec.PublicKey().Hash().ToHex(),
+		"State":       "unused",
+		"KDF":         "scrypt",
+		"Scrypt":      fmt.Sprintf("N=%d,r=%d,p=%d", params.N, params.R, params.P),
+		"Salt":        hex.EncodeToString(salt),
+		"Nonce":       hex.EncodeToString(nonce),
+	}
+	if info.Used {
+		headers["State"] = "used"
+		headers["Message"] = hex.EncodeToString(info.Message[:])
+	}
+
+	plain, err := sec.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sealed := aead.Seal(nil, nonce, plain, keyFileAAD(headers))
+	clear(plain)
+
+	blk := &pem.Block{Type: keyFileType, Headers: headers, Bytes: sealed}
+	return writeFileAtomic(path, pem.EncodeToMemory(blk))
+}
+
+// readKeyFile reads the PEM block and parses the clear headers.
+func readKeyFile(path string) (*pem.Block, KeyFileInfo, error) {
+	var info KeyFileInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, info, err
+	}
+	blk, _ := pem.Decode(data)
+	if blk == nil || blk.Type != keyFileType {
+		return nil, info, fmt.Errorf("%s is not an encrypted key file", path)
+	}
+	h := blk.Headers
+	if h["Version"] != keyFileVersion || h["KDF"] != "scrypt" {
+		return nil, info, fmt.Errorf("%s: key file version %q, KDF %q not supported",
+			path, h["Version"], h["KDF"])
+	}
+
+	info.Suite, err = ParseHashSuite(h["Hash"])
+	if err != nil {
+		return nil, info, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	info.Fingerprint, err = HexToBlock(h["Fingerprint"])
+	if err != nil {
+		return nil, info, fmt.Errorf("%s: bad fingerprint: %s", path, err.Error())
+	}
+	switch h["State"] {
+	case "unused":
+	case "used":
+		info.Used = true
+		msg, err := HexToBlock(h["Message"])
+		if err != nil {
+			return nil, info, fmt.Errorf("%s: bad message: %s", path, err.Error())
+		}
+		info.Message = Message(msg)
+	default:
+		return nil, info, fmt.Errorf("%s: unknown state %q", path, h["State"])
+	}
+	_, err = fmt.Sscanf(h["Scrypt"], "N=%d,r=%d,p=%d",
+		&info.Scrypt.N, &info.Scrypt.R, &info.Scrypt.P)
+	if err != nil {
+		return nil, info, fmt.Errorf("%s: bad scrypt parameters %q", path, h["Scrypt"])
+	}
+	return blk, info, nil
+}
+
+// ReadKeyFileInfo reads just the clear headers; no passphrase needed.
+func ReadKeyFileInfo(path string) (KeyFileInfo, error) {
+	_, info, err := readKeyFile(path)
+	return info, err
+}
+
+// LoadKeyFile decrypts the key file with the passphrase.  Returns an error if
+// the passphrase is wrong or anything in the file has been changed.  The
+// caller should Zero() the key once it's done with it.
+func LoadKeyFile(path string, passphrase []byte) (SecretKey, KeyFileInfo, error) {
+	var sec SecretKey
+	blk, info, err := readKeyFile(path)
+	if err != nil {
+		return sec, info, err
+	}
+	salt, err := hex.DecodeString(blk.Headers["Salt"])
+	if err != nil {
+		return sec, info, fmt.Errorf("%s: bad salt", path)
+	}
+	nonce, err := hex.DecodeString(blk.Headers["Nonce"])
+	if err != nil {
+		return sec, info, fmt.Errorf("%s: bad nonce", path)
+	}
+
+	aead, err := keyFileAEAD(passphrase, salt, info.Scrypt)
+	if err != nil {
+		return sec, info, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if len(nonce) != aead.NonceSize() {
+		return sec, info, fmt.Errorf("%s: bad nonce", path)
+	}
+	plain, err := aead.Open(nil, nonce, blk.Bytes, keyFileAAD(blk.Headers))
+	if err != nil {
+		return sec, info, fmt.Errorf("%s: wrong passphrase, or the file has been changed", path)
+	}
+	err = sec.UnmarshalBinary(plain)
+	clear(plain)
+	if err != nil {
+		return sec, info, err
+	}
+
+	// can't happen unless the file was made by something else, since the
+	// fingerprint is authenticated, but it's cheap to check
+	if sec.Suite != info.Suite || sec.PublicKey().Hash() != info.Fingerprint {
+		sec.Zero()
+		return SecretKey{}, info, fmt.Errorf("%s: key doesn't match its fingerprint", path)
+	}
+	return sec, info, nil
+}
+
+// SignWithKeyFile decrypts the key, signs msg, and marks the key used in the
+// file before returning the signature.  Like KeyStore, it refuses to sign a
+// different message with a used key, and signing the same message again just
+// gives back the same signature.
+func SignWithKeyFile(path string, passphrase []byte, msg Message) (Signature, error) {
+	sec, info, err := LoadKeyFile(path, passphrase)
+	if err != nil {
+		return Signature{}, err
+	}
+	defer sec.Zero()
+
+	if info.Used {
+		if info.Message != msg {
+			return Signature{}, ErrKeyReuse
+		}
+		return Sign(msg, sec), nil
+	}
+
+	info.Used = true
+	info.Message = msg
+	err = SaveKeyFile(path, &sec, info, passphrase)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Sign(msg, sec), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testScrypt keeps the tests quick; the real default is 32 times the work.
+var testScrypt = ScryptParams{N: 1024, R: 8, P: 1}
+
+// TestScryptVectors checks scryptKey against the test vectors in RFC 7914.
+func TestScryptVectors(t *testing.T) {
+	vectors := []struct {
+		password, salt string
+		N, r, p        int
+		out            string
+	}{
+		{"", "", 16, 1, 1,
+			"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+				"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16,
+			"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+				"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, v := range vectors {
+		key, err := scryptKey([]byte(v.password), []byte(v.salt), v.N, v.r, v.p, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(key) != v.out {
+			t.Fatalf("scrypt(%q, %q, %d, %d, %d) = %x, expect %s",
+				v.password, v.salt, v.N, v.r, v.p, key, v.out)
+		}
+	}
+
+	if _, err := scryptKey(nil, nil, 1000, 8, 1, 32); err == nil {
+		t.Fatalf("scryptKey accepted N=1000")
+	}
+}
+
+// TestScryptLimits checks the cost limits right at the edges, where 128*N*r
+// would overflow a 32 bit int.
+func TestScryptLimits(t *testing.T) {
+	for _, c := range []struct {
+		N, r, p int
+		ok      bool
+	}{
+		{1 << 20, 8, 1, true},
+		{1 << 18, 32, 16, true},
+		{1 << 20, 9, 1, false},
+		{1 << 19, 17, 1, false},
+		{1 << 20, 32, 1, false},
+		{1 << 21, 1, 1, false},
+		{1 << 10, 33, 1, false},
+		{1 << 10, 8, 17, false},
+	} {
+		if err := scryptCheckParams(c.N, c.r, c.p); (err == nil) != c.ok {
+			t.Fatalf("scryptCheckParams(%d, %d, %d): got %v, expect ok=%v",
+				c.N, c.r, c.p, err, c.ok)
+		}
+	}
+}
+
+// TestKeyFile saves a key, reads the clear header, loads it back, and signs
+// with it, checking the used state ends up in the file.
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	pass := []byte("correct horse battery staple")
+	sec, pub, err := GenerateKeyWithSuite(SuiteSHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveKeyFile(path, &sec, KeyFileInfo{Scrypt: testScrypt}, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no secrets in the clear
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), sec.ZeroPre[0].ToHex()) {
+		t.Fatalf("key file has a preimage in it")
+	}
+
+	info, err := ReadKeyFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Fingerprint != pub.Hash() || info.Suite != SuiteSHA3 || info.Used ||
+		info.Scrypt != testScrypt {
+		t.Fatalf("header: got %+v", info)
+	}
+
+	loaded, _, err := LoadKeyFile(path, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != sec {
+		t.Fatalf("loaded key doesn't match the saved one")
+	}
+	loaded.Zero()
+	if loaded.ZeroPre[3] != (Block{}) || loaded.OnePre[255] != (Block{}) {
+		t.Fatalf("Zero left something behind")
+	}
+
+	if _, _, err = LoadKeyFile(path, []byte("wrong")); err == nil {
+		t.Fatalf("LoadKeyFile worked with the wrong passphrase")
+	}
+
+	msg := GetMessageFromString("key file")
+	sig, err := SignWithKeyFile(path, pass, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(msg, pub, sig) {
+		t.Fatalf("Verify returned false, expected true")
+	}
+	info, err = ReadKeyFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Used || info.Message != msg {
+		t.Fatalf("key file not marked used: %+v", info)
+	}
+
+	// same message again is fine, a different one isn't
+	if _, err = SignWithKeyFile(path, pass, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SignWithKeyFile(path, pass, GetMessageFromString("other")); err != ErrKeyReuse {
+		t.Fatalf("got %v, expect ErrKeyReuse", err)
+	}
+}
+
+// TestKeyFileTamper changes the clear header of a used key file back to
+// unused, and checks the file no longer decrypts.
+func TestKeyFileTamper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	pass := []byte("pass")
+	sec, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := KeyFileInfo{Used: true, Message: GetMessageFromString("x"), Scrypt: testScrypt}
+	if err = SaveKeyFile(path, &sec, info, pass); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := strings.Replace(string(data), "State: used", "State: unused", 1)
+	i := strings.Index(s, "Message: ")
+	s = s[:i] + s[i+strings.Index(s[i:], "\n")+1:]
+	if err = os.WriteFile(path, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err = ReadKeyFileInfo(path); err != nil || info.Used {
+		t.Fatalf("tampered header: got %+v, %v", info, err)
+	}
+	if _, _, err = LoadKeyFile(path, pass); err == nil {
+		t.Fatalf("LoadKeyFile worked on a tampered file")
+	}
+}
+
+// TestKeyFileScryptLimit checks a key file asking for 4GB of scrypt memory is
+// refused before anything gets allocated.
+func TestKeyFileScryptLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	pass := []byte("pass")
+	sec, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SaveKeyFile(path, &sec, KeyFileInfo{Scrypt: testScrypt}, pass); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := strings.Replace(string(data), "Scrypt: N=1024,r=8,p=1", "Scrypt: N=1048576,r=32,p=1", 1)
+	if err = os.WriteFile(path, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err = LoadKeyFile(path, pass)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatalf("LoadKeyFile accepted N=2^20, r=32")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("LoadKeyFile allocated %d bytes before refusing", n)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Key state tracking, so that a Lamport key never signs two different messages.
+
+// Sign() will happily sign as many messages as you like with the same
+// SecretKey, and Forge() shows how little it takes to go from there to a
+// forgery.  A KeyStore sits in front of Sign() and writes down every key it
+// has used, along with the message it signed, before the signature is handed
+// back.  Asking it to sign a different message with a used key is an error.
+
+// Asking it to sign the same message again is fine: Lamport signatures are
+// deterministic, so it's the exact same signature that was already given out
+// and nothing new is revealed.
+
+// The store is a text file with one line per used key:
+//   <pubkey hash> <message>
+// both in hex.  Keys are identified by PublicKey.Hash() so the file never has
+// anything secret in it.  Every change rewrites the whole file to a temp file,
+// fsyncs it, and renames it over the old one, so a crash leaves either the old
+// or new version but never half of one.  A lock file next to it keeps two
+// processes from using the same store at once.
+
+// ErrKeyReuse is returned when a key that has already signed one message is
+// asked to sign a different one.
+var ErrKeyReuse = errors.New("Lamport key already used to sign a different message")
+
+// A KeyStore keeps track of used keys.  Not safe for concurrent use within a
+// process; the lock file only protects against other processes.
+type KeyStore struct {
+	path     string
+	lockPath string
+	used     map[Block]Message
+}
+
+// OpenKeyStore takes the lock and loads the store at path.  The file is
+// created on the first signature if it doesn't exist yet.  Close() must be
+// called to release the lock.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{
+		path:     path,
+		lockPath: path + ".lock",
+		used:     make(map[Block]Message),
+	}
+
+	lock, err := os.OpenFile(ks.lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf(
+				"keystore %s is locked; if no other process is using it, remove %s",
+				path, ks.lockPath)
+		}
+		return nil, err
+	}
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+	lock.Close()
+
+	err = ks.load()
+	if err != nil {
+		os.Remove(ks.lockPath)
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Close releases the lock.
+func (self *KeyStore) Close() error {
+	return os.Remove(self.lockPath)
+}
+
+// Used returns the message a key signed, if it's been used.
+func (self *KeyStore) Used(pub PublicKey) (Message, bool) {
+	msg, ok := self.used[pub.Hash()]
+	return msg, ok
+}
+
+// Sign signs the message, but only if the key hasn't already signed something
+// else.  The key is recorded as used on disk before the signature is
+// returned, so if this returns an error no signature has been given out.
+func (self *KeyStore) Sign(msg Message, sec SecretKey) (Signature, error) {
+	var sig Signature
+	id := sec.PublicKey().Hash()
+
+	prev, ok := self.used[id]
+	if ok {
+		if prev != msg {
+			return sig, ErrKeyReuse
+		}
+		// same message again; same signature as last time
+		return Sign(msg, sec), nil
+	}
+
+	self.used[id] = msg
+	err := self.save()
+	if err != nil {
+		delete(self.used, id)
+		return sig, err
+	}
+	return Sign(msg, sec), nil
+}
+
+// load reads the store file, if there is one.
+func (self *KeyStore) load() error {
+	f, err := os.Open(self.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return fmt.Errorf("keystore %s line %d: got %d fields, expect 2",
+				self.path, line, len(fields))
+		}
+		id, err := hex.DecodeString(fields[0])
+		if err != nil || len(id) != 32 {
+			return fmt.Errorf("keystore %s line %d: bad key hash", self.path, line)
+		}
+		msg, err := hex.DecodeString(fields[1])
+		if err != nil || len(msg) != 32 {
+			return fmt.Errorf("keystore %s line %d: bad message", self.path, line)
+		}
+		self.used[BlockFromByteSlice(id)] = Message(BlockFromByteSlice(msg))
+	}
+	return scanner.Err()
+}
+
+// save writes the whole store out with writeFileAtomic.
+func (self *KeyStore) save() error {
+	var buf bytes.Buffer
+	for id, msg := range self.used {
+		fmt.Fprintf(&buf, "%s %x\n", id.ToHex(), msg[:])
+	}
+	return writeFileAtomic(self.path, buf.Bytes())
+}
+
+// writeFileAtomic writes data to a temp file, syncs it, and renames it over
+// path.  The directory is synced too so the rename itself is durable.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
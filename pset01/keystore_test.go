@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestKeyStoreReuse signs once, signs the same message again, then tries a
+// different message, which has to fail.  Then it reopens the store to make
+// sure that was all saved to disk.
+func TestKeyStoreReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore")
+
+	ks, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("1")
+
+	sig1, err := ks.Sign(msg, sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(msg, pub, sig1) {
+		t.Fatalf("Verify returned false, expected true")
+	}
+
+	sig2, err := ks.Sign(msg, sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig1 != sig2 {
+		t.Fatalf("signing the same message twice gave different signatures")
+	}
+
+	_, err = ks.Sign(GetMessageFromString("2"), sec)
+	if err != ErrKeyReuse {
+		t.Fatalf("got error %v, expect ErrKeyReuse", err)
+	}
+
+	// a second process can't open it while it's locked
+	_, err = OpenKeyStore(path)
+	if err == nil {
+		t.Fatalf("OpenKeyStore on a locked store should fail")
+	}
+
+	err = ks.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+
+	used, ok := ks.Used(pub)
+	if !ok || used != msg {
+		t.Fatalf("key not recorded as used after reopening")
+	}
+	_, err = ks.Sign(GetMessageFromString("3"), sec)
+	if err != ErrKeyReuse {
+		t.Fatalf("got error %v after reopening, expect ErrKeyReuse", err)
+	}
+}
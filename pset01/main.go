@@ -25,10 +25,16 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"os"
 )
 
 func main() {
 
+	// with arguments, it's the command line tool; see cli.go
+	if len(os.Args) > 1 {
+		os.Exit(RunCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	// Define your message
 	textString := "1"
 	fmt.Printf("%s\n", textString)
@@ -76,14 +82,19 @@ func main() {
 // is the size of both the output (defined by the hash function) and our inputs
 type Block [32]byte
 
+// Keys and signatures also say which hash function they use; see hashsuite.go.
+// The zero value is sha256.
+
 type SecretKey struct {
 	ZeroPre [256]Block
 	OnePre  [256]Block
+	Suite   HashSuite
 }
 
 type PublicKey struct {
 	ZeroHash [256]Block
 	OneHash  [256]Block
+	Suite    HashSuite
 }
 
 // --- Methods on PublicKey type
@@ -91,14 +102,10 @@ type PublicKey struct {
 // ToHex gives a hex string for a PublicKey. no newline at the end
 func (self PublicKey) ToHex() string {
 	// format is zerohash 0...255, onehash 0...255
-	var s string
-	for _, zero := range self.ZeroHash {
-		s += zero.ToHex()
-	}
-	for _, one := range self.OneHash {
-		s += one.ToHex()
-	}
-	return s
+	// with the suite name in front for suites other than sha256
+	bts := appendBlocks(nil, self.ZeroHash[:])
+	bts = appendBlocks(bts, self.OneHash[:])
+	return suiteHexPrefix(self.Suite) + hex.EncodeToString(bts)
 }
 
 // HexToPubkey takes a string from PublicKey.ToHex() and turns it into a pubkey
@@ -106,6 +113,12 @@ func (self PublicKey) ToHex() string {
 func HexToPubkey(s string) (PublicKey, error) {
 	var p PublicKey
 
+	suite, s, err := splitSuiteHex(s)
+	if err != nil {
+		return p, err
+	}
+	p.Suite = suite
+
 	expectedLength := 256 * 2 * 64 // 256 blocks long, 2 rows, 64 hex char per block
 
 	// first, make sure hex string is of correct length
@@ -119,17 +132,54 @@ func HexToPubkey(s string) (PublicKey, error) {
 	if err != nil {
 		return p, err
 	}
-	// we already checked the length of the hex string so don't need to re-check
-	buf := bytes.NewBuffer(bts)
+	// we already checked the length of the hex string so these can't fail
+	readBlocks(p.ZeroHash[:], bts[:256*32])
+	readBlocks(p.OneHash[:], bts[256*32:])
+
+	return p, nil
+}
 
-	for i, _ := range p.ZeroHash {
-		p.ZeroHash[i] = BlockFromByteSlice(buf.Next(32))
+// Hash returns the hash of the whole public key, in the same order as ToHex:
+// all the zero hashes then all the one hashes.  It uses the key's own hash
+// suite.  It's a 32 byte stand-in for the whole key, and is what goes in the
+// leaves of a Merkle tree.
+func (self PublicKey) Hash() Block {
+	h := self.Suite.New()
+	for _, zero := range self.ZeroHash {
+		h.Write(zero[:])
 	}
-	for i, _ := range p.OneHash {
-		p.OneHash[i] = BlockFromByteSlice(buf.Next(32))
+	for _, one := range self.OneHash {
+		h.Write(one[:])
 	}
+	return BlockFromByteSlice(h.Sum(nil))
+}
 
-	return p, nil
+// --- Methods on SecretKey type
+
+// ToHex gives a hex string for a SecretKey, in the same format as
+// PublicKey.ToHex().  This is the whole secret, so be careful where it goes.
+func (self SecretKey) ToHex() string {
+	return PublicKey{self.ZeroPre, self.OnePre, self.Suite}.ToHex()
+}
+
+// HexToSecretKey takes a string from SecretKey.ToHex() and turns it into a
+// secret key.  Same checks as HexToPubkey.
+func HexToSecretKey(s string) (SecretKey, error) {
+	p, err := HexToPubkey(s)
+	if err != nil {
+		return SecretKey{}, err
+	}
+	return SecretKey{p.ZeroHash, p.OneHash, p.Suite}, nil
+}
+
+// PublicKey computes the public key for a secret key by hashing every block.
+func (self SecretKey) PublicKey() PublicKey {
+	pub := PublicKey{Suite: self.Suite}
+	for i := range self.ZeroPre {
+		pub.ZeroHash[i] = self.ZeroPre[i].HashWith(self.Suite)
+		pub.OneHash[i] = self.OnePre[i].HashWith(self.Suite)
+	}
+	return pub
 }
 
 // A message to be signed is just a block.
@@ -156,27 +206,38 @@ func (self Block) IsPreimage(arg Block) bool {
 
 // BlockFromByteSlice returns a block from a variable length byte slice.
 // Watch out!  Silently ignores potential errors like the slice being too
-// long or too short!
+// long or too short!  BlockFromBytes in encoding.go checks the length.
 func BlockFromByteSlice(by []byte) Block {
 	var bl Block
 	copy(bl[:], by)
 	return bl
 }
 
+// HexToBlock takes a string from Block.ToHex() and turns it into a block.
+// Unlike BlockFromByteSlice, this one does check the length.
+func HexToBlock(s string) (Block, error) {
+	var bl Block
+	if len(s) != 64 {
+		return bl, fmt.Errorf("Block string %d characters, expect 64", len(s))
+	}
+	bts, err := hex.DecodeString(s)
+	if err != nil {
+		return bl, err
+	}
+	return BlockFromBytes(bts)
+}
+
 // A signature consists of 32 blocks.  It's a selective reveal of the private
 // key, according to the bits of the message.
 type Signature struct {
 	Preimage [256]Block
+	Suite    HashSuite
 }
 
 // ToHex returns a hex string of a signature
 func (self Signature) ToHex() string {
-	var s string
-	for _, b := range self.Preimage {
-		s += b.ToHex()
-	}
-
-	return s
+	bts := appendBlocks(nil, self.Preimage[:])
+	return suiteHexPrefix(self.Suite) + hex.EncodeToString(bts)
 }
 
 // HexToSignature is the same idea as HexToPubkey, but half as big.  Format is just
@@ -184,6 +245,12 @@ func (self Signature) ToHex() string {
 func HexToSignature(s string) (Signature, error) {
 	var sig Signature
 
+	suite, s, err := splitSuiteHex(s)
+	if err != nil {
+		return sig, err
+	}
+	sig.Suite = suite
+
 	expectedLength := 256 * 64 // 256 blocks long, 1 row, 64 hex char per block
 
 	// first, make sure hex string is of correct length
@@ -197,16 +264,13 @@ func HexToSignature(s string) (Signature, error) {
 	if err != nil {
 		return sig, err
 	}
-	// we already checked the length of the hex string so don't need to re-check
-	buf := bytes.NewBuffer(bts)
-
-	for i, _ := range sig.Preimage {
-		sig.Preimage[i] = BlockFromByteSlice(buf.Next(32))
-	}
+	// we already checked the length of the hex string so this can't fail
+	readBlocks(sig.Preimage[:], bts)
 	return sig, nil
 }
 
 // GetMessageFromString returns a Message which is the hash of the given string.
+// Always sha256; for keys using other suites, see HashSuite.MessageFromString.
 func GetMessageFromString(s string) Message {
 	return sha256.Sum256([]byte(s))
 }
@@ -217,9 +281,19 @@ func GetMessageFromString(s string) Message {
 // error.  It gets randomness from the OS via crypto/rand
 // This can return an error if there is a problem with reading random bytes
 func GenerateKey() (SecretKey, PublicKey, error) {
+	return GenerateKeyWithSuite(SuiteSHA256)
+}
+
+// GenerateKeyWithSuite is GenerateKey, but using the given hash suite for the
+// public key and everything signed with it.
+func GenerateKeyWithSuite(suite HashSuite) (SecretKey, PublicKey, error) {
 	// initialize SecretKey variable 'sec'.  Starts with all 00 bytes.
-	var sec SecretKey
-	var pub PublicKey
+	sec := SecretKey{Suite: suite}
+	pub := PublicKey{Suite: suite}
+
+	if !suite.Valid() {
+		return sec, pub, fmt.Errorf("invalid hash suite %d", uint8(suite))
+	}
 
 	// Generate Sec key: random number of 256 zero blocks and 256 One Blocks, each block is 32 bytes(256 bits)
 
@@ -244,8 +318,8 @@ func GenerateKey() (SecretKey, PublicKey, error) {
 		}
 
 		//fmt.Printf("Init round %d\n", i)
-		pub.ZeroHash[i] = sha256.Sum256(zeroBlock[:])
-		pub.OneHash[i] = sha256.Sum256(oneBlock[:])
+		pub.ZeroHash[i] = suite.Sum(zeroBlock[:])
+		pub.OneHash[i] = suite.Sum(oneBlock[:])
 
 		// fmt.Printf("Zero Block data 32 Bytes: %x\n", sec.ZeroPre[i])
 		// fmt.Printf("Zero Block hash Bytes: %x\n", pub.ZeroHash[i])
@@ -258,7 +332,7 @@ func GenerateKey() (SecretKey, PublicKey, error) {
 
 // Sign takes a message and secret key, and returns a signature.
 func Sign(msg Message, sec SecretKey) Signature {
-	var sig Signature
+	sig := Signature{Suite: sec.Suite}
 
 	for i := 0; i < 32; i++ {
 		// loop over every bytes in the message, saying b.
@@ -283,6 +357,12 @@ func Sign(msg Message, sec SecretKey) Signature {
 // describing the validity of the signature.
 func Verify(msg Message, pub PublicKey, sig Signature) bool {
 
+	// signature has to be from the same suite as the key, and we need to know
+	// how to compute it
+	if sig.Suite != pub.Suite || !pub.Suite.Valid() {
+		return false
+	}
+
 	for i := 0; i < 32; i++ {
 		// loop over every bytes in the message, saying b.
 
@@ -292,7 +372,7 @@ func Verify(msg Message, pub PublicKey, sig Signature) bool {
 	ThisByte:
 		for j := 0; j < 8; j++ {
 			original32bytesNumber := sig.Preimage[8*i+j]
-			original32bytesHash := sha256.Sum256(original32bytesNumber[:])
+			original32bytesHash := pub.Suite.Sum(original32bytesNumber[:])
 			if msg[i]&byte(Mask) == byte(Mask) {
 				// The (8i+j)'th bit is 1
 				// get the original number(signature) from preimage.onepre.
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Merkle signature scheme: many-time keys out of Lamport one-time keys.
+
+// A Lamport key can sign exactly one message (see Forge() for what happens
+// otherwise).  To sign more, make 2^h Lamport keys, hash each public key into
+// a leaf, and build a binary hash tree over the leaves.  The root of the tree
+// is the public key: a single 32 byte block.
+
+// A signature is a Lamport signature from leaf i, along with that leaf's
+// Lamport public key and the authentication path: the sibling of every node
+// on the way from leaf i up to the root.  The verifier checks the Lamport
+// signature, hashes the leaf pubkey, then hashes its way up the tree with the
+// siblings and checks that it ends up at the root.
+
+// The catch is that the signer has state.  Every leaf can only be used once,
+// so the signer has to remember which index to use next.  Lose that and
+// you're back to reusing Lamport keys.
+
+// Tree layout: levels[0] is the leaves, levels[h] is just the root.  Node j on
+// level k has children 2j and 2j+1 on level k-1.  The auth path is ordered
+// from the bottom (sibling of the leaf) to the top (child of the root).
+
+// MaxMerkleHeight is the tallest tree GenerateMerkleKey will build.  All the
+// Lamport secret keys are held in memory, and at 16KB each a height 16 tree
+// is already 1GB.
+const MaxMerkleHeight = 16
+
+// --- Tree functions
+
+// merkleParent hashes two child nodes together to get their parent.
+func merkleParent(left, right Block) Block {
+	var buf [64]byte
+	copy(buf[:32], left[:])
+	copy(buf[32:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleLevels builds every level of the tree.  The number of leaves must be
+// a power of 2.
+func merkleLevels(leaves []Block) [][]Block {
+	levels := [][]Block{leaves}
+	for len(leaves) > 1 {
+		up := make([]Block, len(leaves)/2)
+		for j := range up {
+			up[j] = merkleParent(leaves[2*j], leaves[2*j+1])
+		}
+		levels = append(levels, up)
+		leaves = up
+	}
+	return levels
+}
+
+// merkleAuthPath returns the siblings of every node from leaf idx up to (but
+// not including) the root.
+func merkleAuthPath(levels [][]Block, idx uint64) []Block {
+	path := make([]Block, len(levels)-1)
+	for k := range path {
+		path[k] = levels[k][idx^1]
+		idx >>= 1
+	}
+	return path
+}
+
+// merkleRootFromPath hashes its way up from the leaf at position idx and
+// returns the root it ends up at.  The low bit of idx at each level says
+// whether the current node is on the left (0) or the right (1).
+func merkleRootFromPath(leaf Block, idx uint64, path []Block) Block {
+	node := leaf
+	for _, sibling := range path {
+		if idx&1 == 0 {
+			node = merkleParent(node, sibling)
+		} else {
+			node = merkleParent(sibling, node)
+		}
+		idx >>= 1
+	}
+	return node
+}
+
+// --- Types
+
+// A MerkleSigner holds all the Lamport secret keys and the tree, and keeps
+// track of which leaf to use next.  Not safe for concurrent use.
+type MerkleSigner struct {
+	Height int
+	Next   uint32
+
+	keys   []SecretKey
+	levels [][]Block
+}
+
+// A MerkleSignature is the one-time signature along with everything needed to
+// get from it to the root.
+type MerkleSignature struct {
+	Index uint32
+	Sig   Signature
+	Pub   PublicKey
+	Path  []Block
+}
+
+// --- Functions
+
+// GenerateMerkleKey makes 2^height Lamport keys and builds the tree over them.
+// Returns the signer, and the root which is the public key.
+func GenerateMerkleKey(height int) (*MerkleSigner, Block, error) {
+	var root Block
+	if height < 0 || height > MaxMerkleHeight {
+		return nil, root, fmt.Errorf(
+			"Merkle tree height %d invalid, expect 0 to %d", height, MaxMerkleHeight)
+	}
+
+	signer := &MerkleSigner{Height: height}
+	signer.keys = make([]SecretKey, 1<<uint(height))
+	leaves := make([]Block, len(signer.keys))
+	for i := range signer.keys {
+		sec, pub, err := GenerateKey()
+		if err != nil {
+			return nil, root, err
+		}
+		signer.keys[i] = sec
+		leaves[i] = pub.Hash()
+	}
+	signer.levels = merkleLevels(leaves)
+
+	return signer, signer.Root(), nil
+}
+
+// Root returns the root of the tree, which is the public key.
+func (self *MerkleSigner) Root() Block {
+	return self.levels[self.Height][0]
+}
+
+// Remaining returns how many more messages can be signed.
+func (self *MerkleSigner) Remaining() int {
+	return len(self.keys) - int(self.Next)
+}
+
+// Sign signs with the next unused leaf, and moves on to the one after that.
+// Returns an error once every leaf has been used.
+func (self *MerkleSigner) Sign(msg Message) (MerkleSignature, error) {
+	var sig MerkleSignature
+	if self.Remaining() <= 0 {
+		return sig, fmt.Errorf("all %d Merkle leaves used", len(self.keys))
+	}
+
+	idx := self.Next
+	self.Next++
+
+	sec := self.keys[idx]
+	sig.Index = idx
+	sig.Sig = Sign(msg, sec)
+	sig.Pub = sec.PublicKey()
+	sig.Path = merkleAuthPath(self.levels, uint64(idx))
+
+	// never going to need this one again, so don't keep it around
+	self.keys[idx] = SecretKey{}
+
+	return sig, nil
+}
+
+// VerifyMerkle checks the Lamport signature, then walks the auth path from the
+// Lamport pubkey's leaf up and checks that it gets to the root.
+func VerifyMerkle(msg Message, root Block, sig MerkleSignature) bool {
+	if len(sig.Path) > MaxMerkleHeight || uint64(sig.Index)>>uint(len(sig.Path)) != 0 {
+		return false
+	}
+	if !Verify(msg, sig.Pub, sig.Sig) {
+		return false
+	}
+	return merkleRootFromPath(sig.Pub.Hash(), uint64(sig.Index), sig.Path) == root
+}
+
+// --- Hex encoding
+
+// ToHex returns a hex string of a Merkle signature.  Format is 4 bytes of
+// index (big endian), the Lamport signature, the Lamport pubkey, then the
+// auth path from the bottom up.  The tree height is however many path blocks
+// are left at the end.
+func (self MerkleSignature) ToHex() string {
+	var idx [4]byte
+	binary.BigEndian.PutUint32(idx[:], self.Index)
+	path := hex.EncodeToString(appendBlocks(nil, self.Path))
+	return hex.EncodeToString(idx[:]) + self.Sig.ToHex() + self.Pub.ToHex() + path
+}
+
+// HexToMerkleSignature takes a string from MerkleSignature.ToHex() and turns it
+// into a signature.
+func HexToMerkleSignature(s string) (MerkleSignature, error) {
+	var sig MerkleSignature
+
+	minLength := 8 + 256*64 + 512*64 // index, signature, pubkey
+
+	if len(s) < minLength || (len(s)-minLength)%64 != 0 {
+		return sig, fmt.Errorf(
+			"Merkle signature string %d characters, expect %d plus a multiple of 64",
+			len(s), minLength)
+	}
+	height := (len(s) - minLength) / 64
+	if height > MaxMerkleHeight {
+		return sig, fmt.Errorf(
+			"Merkle signature path %d blocks, max %d", height, MaxMerkleHeight)
+	}
+
+	idx, err := hex.DecodeString(s[:8])
+	if err != nil {
+		return sig, err
+	}
+	sig.Index = binary.BigEndian.Uint32(idx)
+
+	sig.Sig, err = HexToSignature(s[8 : 8+256*64])
+	if err != nil {
+		return sig, err
+	}
+	sig.Pub, err = HexToPubkey(s[8+256*64 : minLength])
+	if err != nil {
+		return sig, err
+	}
+
+	bts, err := hex.DecodeString(s[minLength:])
+	if err != nil {
+		return sig, err
+	}
+	sig.Path = make([]Block, height)
+	err = readBlocks(sig.Path, bts)
+	return sig, err
+}
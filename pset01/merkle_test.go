@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestMerkleSignAll uses up every leaf of a small tree, verifying each
+// signature against the root, and makes sure signing stops after that.
+func TestMerkleSignAll(t *testing.T) {
+	signer, root, err := GenerateMerkleKey(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		msg := GetMessageFromString(fmt.Sprintf("good %d", i))
+		sig, err := signer.Sign(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Index != uint32(i) || len(sig.Path) != 3 {
+			t.Fatalf("got index %d path %d, expect %d and 3",
+				sig.Index, len(sig.Path), i)
+		}
+		if !VerifyMerkle(msg, root, sig) {
+			t.Fatalf("VerifyMerkle returned false for leaf %d", i)
+		}
+	}
+
+	if signer.Remaining() != 0 {
+		t.Fatalf("%d leaves remaining, expect 0", signer.Remaining())
+	}
+	_, err = signer.Sign(GetMessageFromString("one too many"))
+	if err == nil {
+		t.Fatalf("Sign with every leaf used should fail")
+	}
+}
+
+// TestMerkleBadSig messes with the index and the path, and tries a different
+// message.  All should fail.
+func TestMerkleBadSig(t *testing.T) {
+	signer, root, err := GenerateMerkleKey(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("bad")
+	sig, err := signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if VerifyMerkle(GetMessageFromString("worse"), root, sig) {
+		t.Fatalf("VerifyMerkle returned true for a different message")
+	}
+
+	sig.Index = 1
+	if VerifyMerkle(msg, root, sig) {
+		t.Fatalf("VerifyMerkle returned true with the wrong index")
+	}
+	sig.Index = 4
+	if VerifyMerkle(msg, root, sig) {
+		t.Fatalf("VerifyMerkle returned true with an index past the tree")
+	}
+	sig.Index = 0
+
+	sig.Path[1] = sig.Path[1].Hash()
+	if VerifyMerkle(msg, root, sig) {
+		t.Fatalf("VerifyMerkle returned true with a bad path")
+	}
+}
+
+// TestMerkleHex round trips a signature through hex.
+func TestMerkleHex(t *testing.T) {
+	signer, root, err := GenerateMerkleKey(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("hex")
+	sig, err := signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig2, err := HexToMerkleSignature(sig.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyMerkle(msg, root, sig2) {
+		t.Fatalf("VerifyMerkle after hex round trip returned false")
+	}
+
+	_, err = HexToMerkleSignature(sig.ToHex()[:len(sig.ToHex())-2])
+	if err == nil {
+		t.Fatalf("HexToMerkleSignature accepted a truncated string")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// k-of-n co-signing with Lamport keys.
+
+// A MultiKey is n ordinary Lamport public keys and a threshold k.  A
+// MultiSignature is a bundle of ordinary signatures, each tagged with the
+// index of the key that made it.  It's good if at least k different keys in
+// the set signed the message.  Nothing clever is going on; it's just a
+// convenient way to carry them around together and check them in one go.
+
+// Every key is still one-time: each co-signer's key signs one message, ever.
+
+// Hex format, for both:
+//   MultiKey:        <k><n>,<pubkey>,<pubkey>,...
+//   MultiSignature:  <index>/<signature>,<index>/<signature>,...
+// with k, n and the indexes as 1 byte in hex, and the keys and signatures
+// from their own ToHex() functions.
+
+// MaxMultiKeys is the most keys a MultiKey can hold, so an index fits in a
+// byte.
+const MaxMultiKeys = 255
+
+// A MultiKey is a set of public keys, any Threshold of which can sign.
+type MultiKey struct {
+	Threshold int
+	Keys      []PublicKey
+}
+
+// A MultiSignature is signatures from some of the keys in a MultiKey.
+// Sigs[i] was made by Keys[Signers[i]].
+type MultiSignature struct {
+	Signers []int
+	Sigs    []Signature
+}
+
+// NewMultiKey makes a k-of-n key.  Returns an error unless 1 <= threshold <=
+// len(keys) <= MaxMultiKeys, or if the same key is in there twice.
+func NewMultiKey(threshold int, keys []PublicKey) (MultiKey, error) {
+	mk := MultiKey{Threshold: threshold, Keys: keys}
+	return mk, mk.check()
+}
+
+// check makes sure the threshold and keys make sense.
+func (self MultiKey) check() error {
+	n := len(self.Keys)
+	if n < 1 || n > MaxMultiKeys {
+		return fmt.Errorf("multisig has %d keys, expect 1 to %d", n, MaxMultiKeys)
+	}
+	if self.Threshold < 1 || self.Threshold > n {
+		return fmt.Errorf("multisig threshold %d invalid, expect 1 to %d", self.Threshold, n)
+	}
+	seen := make(map[Block]int)
+	for i, pub := range self.Keys {
+		id := pub.Hash()
+		if j, ok := seen[id]; ok {
+			return fmt.Errorf("multisig keys %d and %d are the same", j, i)
+		}
+		seen[id] = i
+	}
+	return nil
+}
+
+// Add puts a signature from key index signer into the bundle.
+func (self *MultiSignature) Add(signer int, sig Signature) {
+	self.Signers = append(self.Signers, signer)
+	self.Sigs = append(self.Sigs, sig)
+}
+
+// VerifyMulti checks every signature in the bundle and returns the indexes of
+// the keys whose signatures are good, in the order they appear.  Returns an
+// error if the bundle is malformed (a signer index out of range or listed
+// twice) or if fewer than the threshold are good; the good ones are still
+// returned in that case.
+func VerifyMulti(msg Message, mk MultiKey, msig MultiSignature) ([]int, error) {
+	if err := mk.check(); err != nil {
+		return nil, err
+	}
+	if len(msig.Signers) != len(msig.Sigs) {
+		return nil, fmt.Errorf("multisig has %d signers and %d signatures",
+			len(msig.Signers), len(msig.Sigs))
+	}
+
+	seen := make(map[int]bool)
+	for _, signer := range msig.Signers {
+		if signer < 0 || signer >= len(mk.Keys) {
+			return nil, fmt.Errorf("multisig signer %d out of range, %d keys", signer, len(mk.Keys))
+		}
+		if seen[signer] {
+			return nil, fmt.Errorf("multisig signer %d listed more than once", signer)
+		}
+		seen[signer] = true
+	}
+
+	var valid []int
+	for i, signer := range msig.Signers {
+		if Verify(msg, mk.Keys[signer], msig.Sigs[i]) {
+			valid = append(valid, signer)
+		}
+	}
+	if len(valid) < mk.Threshold {
+		return valid, fmt.Errorf("%d valid signatures, need %d of %d",
+			len(valid), mk.Threshold, len(mk.Keys))
+	}
+	return valid, nil
+}
+
+// --- Hex encoding
+
+// ToHex returns a hex string of a MultiKey.
+func (self MultiKey) ToHex() string {
+	parts := []string{hex.EncodeToString([]byte{byte(self.Threshold), byte(len(self.Keys))})}
+	for _, pub := range self.Keys {
+		parts = append(parts, pub.ToHex())
+	}
+	return strings.Join(parts, ",")
+}
+
+// HexToMultiKey takes a string from MultiKey.ToHex() and turns it into a
+// MultiKey, with the same checks as NewMultiKey.
+func HexToMultiKey(s string) (MultiKey, error) {
+	var mk MultiKey
+	parts := strings.Split(s, ",")
+	header, err := hex.DecodeString(parts[0])
+	if err != nil || len(header) != 2 {
+		return mk, fmt.Errorf("multisig key header %q invalid", parts[0])
+	}
+	if int(header[1]) != len(parts)-1 {
+		return mk, fmt.Errorf("multisig key says %d keys, has %d", header[1], len(parts)-1)
+	}
+	var keys []PublicKey
+	for i, p := range parts[1:] {
+		pub, err := HexToPubkey(p)
+		if err != nil {
+			return mk, fmt.Errorf("multisig key %d: %s", i, err.Error())
+		}
+		keys = append(keys, pub)
+	}
+	return NewMultiKey(int(header[0]), keys)
+}
+
+// ToHex returns a hex string of a MultiSignature.
+func (self MultiSignature) ToHex() string {
+	var parts []string
+	for i, sig := range self.Sigs {
+		parts = append(parts, fmt.Sprintf("%02x/%s", self.Signers[i], sig.ToHex()))
+	}
+	return strings.Join(parts, ",")
+}
+
+// HexToMultiSignature takes a string from MultiSignature.ToHex() and turns it
+// into a MultiSignature.  Whether the signers make sense is up to
+// VerifyMulti.
+func HexToMultiSignature(s string) (MultiSignature, error) {
+	var msig MultiSignature
+	if s == "" {
+		return msig, nil
+	}
+	for i, p := range strings.Split(s, ",") {
+		idx, sigHex, ok := strings.Cut(p, "/")
+		signer, err := hex.DecodeString(idx)
+		if !ok || err != nil || len(signer) != 1 {
+			return msig, fmt.Errorf("multisig signature %d: bad signer index", i)
+		}
+		sig, err := HexToSignature(sigHex)
+		if err != nil {
+			return msig, fmt.Errorf("multisig signature %d: %s", i, err.Error())
+		}
+		msig.Add(int(signer[0]), sig)
+	}
+	return msig, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+)
+
+// multiKeys makes n keypairs for the multisig tests.
+func multiKeys(t *testing.T, n int) ([]SecretKey, []PublicKey) {
+	var secs []SecretKey
+	var pubs []PublicKey
+	for i := 0; i < n; i++ {
+		sec, pub, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		secs, pubs = append(secs, sec), append(pubs, pub)
+	}
+	return secs, pubs
+}
+
+// TestMultiSig does a 2-of-3: one signer isn't enough, two are, and a bad
+// signature in the bundle gets left out of the valid list.
+func TestMultiSig(t *testing.T) {
+	secs, pubs := multiKeys(t, 3)
+	mk, err := NewMultiKey(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("approve")
+
+	var msig MultiSignature
+	msig.Add(2, Sign(msg, secs[2]))
+	valid, err := VerifyMulti(msg, mk, msig)
+	if err == nil || len(valid) != 1 || valid[0] != 2 {
+		t.Fatalf("1 of 2: got %v, %v", valid, err)
+	}
+
+	// signer 1's signature is on the wrong message
+	msig.Add(1, Sign(GetMessageFromString("something else"), secs[1]))
+	valid, err = VerifyMulti(msg, mk, msig)
+	if err == nil || len(valid) != 1 {
+		t.Fatalf("1 good 1 bad: got %v, %v", valid, err)
+	}
+
+	msig.Add(0, Sign(msg, secs[0]))
+	valid, err = VerifyMulti(msg, mk, msig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valid) != 2 || valid[0] != 2 || valid[1] != 0 {
+		t.Fatalf("valid signers %v, expect [2 0]", valid)
+	}
+}
+
+// TestMultiSigReject checks duplicate signers, out of range signers, and bad
+// keys and thresholds.
+func TestMultiSigReject(t *testing.T) {
+	secs, pubs := multiKeys(t, 3)
+	mk, err := NewMultiKey(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("approve")
+	sig := Sign(msg, secs[0])
+
+	// the same signer twice doesn't count twice
+	var dup MultiSignature
+	dup.Add(0, sig)
+	dup.Add(0, sig)
+	if _, err = VerifyMulti(msg, mk, dup); err == nil {
+		t.Fatalf("VerifyMulti accepted a duplicate signer")
+	}
+
+	var out MultiSignature
+	out.Add(0, sig)
+	out.Add(3, sig)
+	if _, err = VerifyMulti(msg, mk, out); err == nil {
+		t.Fatalf("VerifyMulti accepted an out of range signer")
+	}
+
+	if _, err = NewMultiKey(4, pubs); err == nil {
+		t.Fatalf("NewMultiKey accepted threshold 4 of 3")
+	}
+	if _, err = NewMultiKey(0, pubs); err == nil {
+		t.Fatalf("NewMultiKey accepted threshold 0")
+	}
+	if _, err = NewMultiKey(2, []PublicKey{pubs[0], pubs[1], pubs[0]}); err == nil {
+		t.Fatalf("NewMultiKey accepted the same key twice")
+	}
+}
+
+// TestMultiSigHex round trips a key and signature bundle through hex.
+func TestMultiSigHex(t *testing.T) {
+	secs, pubs := multiKeys(t, 3)
+	sha3Sec, sha3Pub, err := GenerateKeyWithSuite(SuiteSHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secs, pubs = append(secs, sha3Sec), append(pubs, sha3Pub)
+	mk, err := NewMultiKey(3, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("hex")
+	var msig MultiSignature
+	for _, i := range []int{3, 0, 2} {
+		msig.Add(i, Sign(msg, secs[i]))
+	}
+
+	mk2, err := HexToMultiKey(mk.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msig2, err := HexToMultiSignature(msig.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := VerifyMulti(msg, mk2, msig2)
+	if err != nil || len(valid) != 3 {
+		t.Fatalf("after hex round trip: got %v, %v", valid, err)
+	}
+
+	if _, err = HexToMultiKey("0203," + pubs[0].ToHex()); err == nil {
+		t.Fatalf("HexToMultiKey accepted the wrong key count")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// Property tests: things that should hold for every key and message, checked
+// on a bunch of them.  Keys come from DeriveKey with a seeded PRNG picking
+// the seeds and messages, so a failure can be reproduced.
+
+// propertyKeys makes n keypairs per suite and a random message for each.
+func propertyKeys(t *testing.T, n int) ([]SecretKey, []PublicKey, []Message) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var secs []SecretKey
+	var pubs []PublicKey
+	var msgs []Message
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3, SuiteBLAKE2s} {
+		for i := 0; i < n; i++ {
+			var seed Block
+			var msg Message
+			for j := range seed {
+				seed[j] = byte(rng.Uint32())
+				msg[j] = byte(rng.Uint32())
+			}
+			sec, pub := DeriveKeyWithSuite(suite, seed, rng.Uint64())
+			secs, pubs, msgs = append(secs, sec), append(pubs, pub), append(msgs, msg)
+		}
+	}
+	return secs, pubs, msgs
+}
+
+// TestPropertySignVerify checks that every signature verifies, and stops
+// verifying if any single bit of the message is flipped.
+func TestPropertySignVerify(t *testing.T) {
+	secs, pubs, msgs := propertyKeys(t, 3)
+	for k := range secs {
+		sig := Sign(msgs[k], secs[k])
+		if !Verify(msgs[k], pubs[k], sig) {
+			t.Fatalf("key %d: Verify returned false, expected true", k)
+		}
+		for bit := 0; bit < 256; bit++ {
+			msg := msgs[k]
+			msg[bit/8] ^= 0x80 >> uint(bit%8)
+			if Verify(msg, pubs[k], sig) {
+				t.Fatalf("key %d: verified with message bit %d flipped", k, bit)
+			}
+		}
+	}
+}
+
+// TestPropertyBlockMutation changes one byte of each signature block in turn
+// and checks that the signature stops verifying.
+func TestPropertyBlockMutation(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	secs, pubs, msgs := propertyKeys(t, 2)
+	for k := range secs {
+		sig := Sign(msgs[k], secs[k])
+		for i := range sig.Preimage {
+			bad := sig
+			bad.Preimage[i][rng.IntN(32)] ^= byte(1 + rng.IntN(255))
+			if Verify(msgs[k], pubs[k], bad) {
+				t.Fatalf("key %d: verified with block %d changed", k, i)
+			}
+		}
+	}
+}
+
+// TestPropertyEncodings round trips keys and signatures through hex, binary
+// and armor, and checks they come back the same.
+func TestPropertyEncodings(t *testing.T) {
+	secs, pubs, msgs := propertyKeys(t, 2)
+	for k := range secs {
+		sec, pub, sig := secs[k], pubs[k], Sign(msgs[k], secs[k])
+
+		pub2, err := HexToPubkey(pub.ToHex())
+		if err != nil || pub2 != pub {
+			t.Fatalf("key %d: pubkey hex round trip: %v", k, err)
+		}
+		sec2, err := HexToSecretKey(sec.ToHex())
+		if err != nil || sec2 != sec {
+			t.Fatalf("key %d: secret key hex round trip: %v", k, err)
+		}
+		sig2, err := HexToSignature(sig.ToHex())
+		if err != nil || sig2 != sig {
+			t.Fatalf("key %d: signature hex round trip: %v", k, err)
+		}
+
+		var pub3 PublicKey
+		var sec3 SecretKey
+		var sig3 Signature
+		bin, _ := pub.MarshalBinary()
+		if err = pub3.UnmarshalBinary(bin); err != nil || pub3 != pub {
+			t.Fatalf("key %d: pubkey binary round trip: %v", k, err)
+		}
+		bin, _ = sec.MarshalBinary()
+		if err = sec3.UnmarshalBinary(bin); err != nil || sec3 != sec {
+			t.Fatalf("key %d: secret key binary round trip: %v", k, err)
+		}
+		bin, _ = sig.MarshalBinary()
+		if err = sig3.UnmarshalBinary(bin); err != nil || sig3 != sig {
+			t.Fatalf("key %d: signature binary round trip: %v", k, err)
+		}
+
+		pub4, err := ArmorToPubkey(pub.Armor())
+		if err != nil || pub4 != pub {
+			t.Fatalf("key %d: pubkey armor round trip: %v", k, err)
+		}
+		sec4, err := ArmorToSecretKey(sec.Armor())
+		if err != nil || sec4 != sec {
+			t.Fatalf("key %d: secret key armor round trip: %v", k, err)
+		}
+		sig4, err := ArmorToSignature(sig.Armor())
+		if err != nil || sig4 != sig {
+			t.Fatalf("key %d: signature armor round trip: %v", k, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Salted, domain separated signatures on byte strings.
+
+// Sign() takes a Message, which is whatever 32 bytes the caller hashed up.
+// With GetMessageFromString that's a bare sha256 of the string, so the same
+// hash means the same thing in every program that uses these keys, and the
+// signer signs exactly the hash the requester asked for.
+
+// SignBytes() hashes the message itself, as
+//   H("lamport-bytes-v1" | len(context) | context | salt | msg)
+// with H the key's hash suite and len(context) a single byte.
+//   - The label keeps these hashes from ever being equal to the hash of some
+//     other kind of input.
+//   - The context says what the signature is for ("release v2", "login",
+//     ...), so a signature made for one purpose doesn't verify for another.
+//   - The salt is 32 random bytes picked by the signer for every signature
+//     and carried in the signature.  Whoever asks for a signature can't
+//     predict the hash that gets signed, so they can't steer which preimages
+//     a reused key gives away.  With the 4 signatures in signatures.go that's
+//     what made the key so easy to pick apart: every signed hash was fixed in
+//     advance.
+
+// A forger who already has preimages still picks their own salt, so the salt
+// doesn't make grinding any slower once a key has been reused; the only real
+// fix for that is to not reuse keys (see KeyStore).
+
+// MaxContextLength is the longest context string allowed, since its length is
+// hashed as a single byte.
+const MaxContextLength = 255
+
+const saltedLabel = "lamport-bytes-v1"
+
+// A SaltedSignature is a Lamport signature along with the salt that went
+// into the hash it signs.
+type SaltedSignature struct {
+	Salt Block
+	Sig  Signature
+}
+
+// SaltedMessage computes the hash that gets signed for msg under the given
+// context and salt.  Returns an error if the context is too long or the suite
+// is invalid.
+func SaltedMessage(suite HashSuite, context string, salt Block, msg []byte) (Message, error) {
+	var m Message
+	if len(context) > MaxContextLength {
+		return m, fmt.Errorf("context %d bytes, max %d", len(context), MaxContextLength)
+	}
+	h := suite.New()
+	if h == nil {
+		return m, fmt.Errorf("invalid hash suite %d", uint8(suite))
+	}
+	h.Write([]byte(saltedLabel))
+	h.Write([]byte{byte(len(context))})
+	h.Write([]byte(context))
+	h.Write(salt[:])
+	h.Write(msg)
+	copy(m[:], h.Sum(nil))
+	return m, nil
+}
+
+// SignBytes picks a random salt and signs msg under the context.  The usual
+// one-time rule applies: each key signs one message, ever.
+func SignBytes(msg []byte, context string, sec SecretKey) (SaltedSignature, error) {
+	var ssig SaltedSignature
+	_, err := rand.Read(ssig.Salt[:])
+	if err != nil {
+		return ssig, err
+	}
+	m, err := SaltedMessage(sec.Suite, context, ssig.Salt, msg)
+	if err != nil {
+		return ssig, err
+	}
+	ssig.Sig = Sign(m, sec)
+	return ssig, nil
+}
+
+// VerifyBytes recomputes the salted hash and verifies the Lamport signature
+// on it.  The context has to be the same one it was signed with.
+func VerifyBytes(msg []byte, context string, pub PublicKey, ssig SaltedSignature) bool {
+	m, err := SaltedMessage(pub.Suite, context, ssig.Salt, msg)
+	if err != nil {
+		return false
+	}
+	return Verify(m, pub, ssig.Sig)
+}
+
+// ToHex returns a hex string of a salted signature: the Lamport signature
+// (with its suite prefix, if any) followed by the salt.
+func (self SaltedSignature) ToHex() string {
+	return self.Sig.ToHex() + self.Salt.ToHex()
+}
+
+// HexToSaltedSignature takes a string from SaltedSignature.ToHex() and turns
+// it into a salted signature.
+func HexToSaltedSignature(s string) (SaltedSignature, error) {
+	var ssig SaltedSignature
+	if len(s) < 64 {
+		return ssig, fmt.Errorf("salted signature string %d characters, too short", len(s))
+	}
+	salt, err := hex.DecodeString(s[len(s)-64:])
+	if err != nil {
+		return ssig, err
+	}
+	ssig.Salt = BlockFromByteSlice(salt)
+	ssig.Sig, err = HexToSignature(s[:len(s)-64])
+	return ssig, err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSaltedGoodSig signs a byte message under a context and verifies it.
+func TestSaltedGoodSig(t *testing.T) {
+	msg := []byte("any length message\x00 with \xff whatever bytes in it")
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteBLAKE2s} {
+		sec, pub, err := GenerateKeyWithSuite(suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ssig, err := SignBytes(msg, "release", sec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyBytes(msg, "release", pub, ssig) {
+			t.Fatalf("%s: VerifyBytes returned false, expected true", suite)
+		}
+	}
+}
+
+// TestSaltedBadSig checks that the message, context and salt all matter, and
+// that the salted hash isn't the plain hash of the message.
+func TestSaltedBadSig(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("bad")
+	ssig, err := SignBytes(msg, "release", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if VerifyBytes([]byte("worse"), "release", pub, ssig) {
+		t.Fatalf("VerifyBytes returned true for a different message")
+	}
+	if VerifyBytes(msg, "login", pub, ssig) {
+		t.Fatalf("VerifyBytes returned true for a different context")
+	}
+	if VerifyBytes(msg, "", pub, ssig) {
+		t.Fatalf("VerifyBytes returned true for an empty context")
+	}
+	changed := ssig
+	changed.Salt[0] ^= 1
+	if VerifyBytes(msg, "release", pub, changed) {
+		t.Fatalf("VerifyBytes returned true for a changed salt")
+	}
+	if Verify(GetMessageFromString("bad"), pub, ssig.Sig) {
+		t.Fatalf("salted signature verified as a plain one")
+	}
+
+	// context length goes in a single byte, so the boundary has to be exact:
+	// moving a byte from the context into the message changes the hash
+	a, _ := SaltedMessage(SuiteSHA256, "ab", ssig.Salt, []byte("c"))
+	b, _ := SaltedMessage(SuiteSHA256, "a", ssig.Salt, []byte("bc"))
+	if a == b {
+		t.Fatalf("context and message boundary doesn't change the hash")
+	}
+
+	if _, err = SignBytes(msg, strings.Repeat("x", MaxContextLength+1), sec); err == nil {
+		t.Fatalf("SignBytes should fail with a %d byte context", MaxContextLength+1)
+	}
+}
+
+// TestSaltedFresh checks that signing the same thing twice uses different
+// salts, so different hashes get signed.
+func TestSaltedFresh(t *testing.T) {
+	sec, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := SignBytes([]byte("same"), "ctx", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SignBytes([]byte("same"), "ctx", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Salt == b.Salt || a.Sig == b.Sig {
+		t.Fatalf("two signatures on the same message got the same salt")
+	}
+}
+
+// TestSaltedHex round trips a salted signature through hex.
+func TestSaltedHex(t *testing.T) {
+	sec, pub, err := GenerateKeyWithSuite(SuiteSHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssig, err := SignBytes([]byte("hex"), "ctx", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := HexToSaltedSignature(ssig.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back != ssig || !VerifyBytes([]byte("hex"), "ctx", pub, back) {
+		t.Fatalf("salted signature changed in hex round trip")
+	}
+}
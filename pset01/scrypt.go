@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// scrypt, as in RFC 7914.
+
+// Key files are encrypted with a key derived from a passphrase, and scrypt
+// makes each guess at the passphrase cost a lot of memory as well as time.
+// The standard library doesn't have it, so like BLAKE2s (blake2s.go) here's a
+// small implementation straight from the RFC.
+
+// scrypt runs PBKDF2-HMAC-SHA256 with a single iteration on both ends, and
+// that's simple enough to do by hand.  Doing it here rather than with
+// crypto/pbkdf2 also means the passphrase never gets copied into a string,
+// which couldn't be zeroed afterwards.
+
+// Limits on the cost parameters, so that a key file from somewhere else can't
+// make us allocate gigabytes.  Memory use is 128*N*r bytes, so N and r are
+// capped together as well as on their own: N=2^20, r=8 is the most, 1GB.
+// The parameters are in the clear header, and scrypt has to run before the
+// GCM tag can tell us whether they were tampered with.
+const (
+	scryptMaxN   = 1 << 20
+	scryptMaxR   = 32
+	scryptMaxP   = 16
+	scryptMaxMem = 1 << 30
+)
+
+// scryptCheckParams checks the cost parameters against the limits.  N must
+// be a power of 2 greater than 1.
+func scryptCheckParams(N, r, p int) error {
+	if N < 2 || N&(N-1) != 0 || N > scryptMaxN {
+		return fmt.Errorf("scrypt N=%d invalid, expect a power of 2 up to %d", N, scryptMaxN)
+	}
+	if r < 1 || r > scryptMaxR || p < 1 || p > scryptMaxP {
+		return fmt.Errorf("scrypt r=%d p=%d invalid, max r=%d p=%d",
+			r, p, scryptMaxR, scryptMaxP)
+	}
+	// divide rather than multiply: 128*N*r can be 2^32, which overflows a
+	// 32 bit int
+	if N > scryptMaxMem/(128*r) {
+		return fmt.Errorf("scrypt N=%d r=%d needs %d bytes, max %d",
+			N, r, 128*uint64(N)*uint64(r), scryptMaxMem)
+	}
+	return nil
+}
+
+// scryptKey derives a keyLen byte key from the password and salt.
+func scryptKey(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	err := scryptCheckParams(N, r, p)
+	if err != nil {
+		return nil, err
+	}
+
+	b := pbkdf2SHA256(password, salt, p*128*r)
+	x := make([]uint32, 32*r)
+	y := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*N)
+	for i := 0; i < p; i++ {
+		scryptROMix(b[i*128*r:(i+1)*128*r], r, N, x, y, v)
+	}
+	clear(x)
+	clear(y)
+	clear(v)
+
+	key := pbkdf2SHA256(password, b, keyLen)
+	clear(b)
+	return key, nil
+}
+
+// pbkdf2SHA256 is PBKDF2-HMAC-SHA256 with one iteration, which is just
+// HMAC(password, salt | block number) for each 32 byte block of output.
+func pbkdf2SHA256(password, salt []byte, keyLen int) []byte {
+	out := make([]byte, 0, keyLen+sha256.Size)
+	mac := hmac.New(sha256.New, password)
+	var ctr [4]byte
+	for n := uint32(1); len(out) < keyLen; n++ {
+		binary.BigEndian.PutUint32(ctr[:], n)
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(ctr[:])
+		out = mac.Sum(out)
+	}
+	clear(out[keyLen:cap(out)])
+	return out[:keyLen]
+}
+
+// scryptROMix mixes one 128*r byte block b in place.  x and y are scratch
+// space of 32*r words, and v of 32*r*N.
+func scryptROMix(b []byte, r, N int, x, y, v []uint32) {
+	words := 32 * r
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	for i := 0; i < N; i++ {
+		copy(v[i*words:], x)
+		scryptBlockMix(x, y, r)
+		x, y = y, x
+	}
+	for i := 0; i < N; i++ {
+		// integerify: first word of the last 64 byte block, mod N
+		j := int(x[words-16] & uint32(N-1))
+		for k := range x {
+			x[k] ^= v[j*words+k]
+		}
+		scryptBlockMix(x, y, r)
+		x, y = y, x
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[4*i:], w)
+	}
+}
+
+// scryptBlockMix runs salsa20/8 over the 2r 64 byte blocks of in, and writes
+// the results to out: the even numbered ones first, then the odd ones.
+func scryptBlockMix(in, out []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], in[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := range t {
+			t[k] ^= in[i*16+k]
+		}
+		salsa208(&t)
+		dst := (i / 2) * 16
+		if i%2 == 1 {
+			dst += r * 16
+		}
+		copy(out[dst:], t[:])
+	}
+}
+
+// salsa208 is the Salsa20/8 core: 8 rounds, then the input added back in.
+func salsa208(b *[16]uint32) {
+	x := *b
+	qr := func(i, j, k, l int) {
+		x[j] ^= bits.RotateLeft32(x[i]+x[l], 7)
+		x[k] ^= bits.RotateLeft32(x[j]+x[i], 9)
+		x[l] ^= bits.RotateLeft32(x[k]+x[j], 13)
+		x[i] ^= bits.RotateLeft32(x[l]+x[k], 18)
+	}
+	for i := 0; i < 8; i += 2 {
+		// columns
+		qr(0, 4, 8, 12)
+		qr(5, 9, 13, 1)
+		qr(10, 14, 2, 6)
+		qr(15, 3, 7, 11)
+		// rows
+		qr(0, 1, 2, 3)
+		qr(5, 6, 7, 4)
+		qr(10, 11, 8, 9)
+		qr(15, 12, 13, 14)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding"
+	"fmt"
+	"hash"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Forgery search: hash candidate messages until one can be forged.
+
+// Candidates come from a MessageTemplate, one per value of a 64 bit counter,
+// so the whole search is deterministic: the same template always tries the
+// same messages in the same order.  That makes it possible to stop a search
+// and pick it up again later.  Workers take the counter space in chunks, so
+// each one works through its own range.  Since chunks are handed out in
+// order and each worker finishes a chunk before taking the next, every
+// counter below the lowest chunk anyone is working on has been tried.  That
+// point is what gets written to the checkpoint file.
+
+// When a worker finds a forgeable message it doesn't stop the others right
+// away.  Anyone on a chunk below the find keeps going until they pass it, so
+// the message returned is always the lowest forgeable counter from the start
+// point on, no matter how many workers there are or how fast they run.
+
+// Finding a forgeable message is a coin flip every time (with a very
+// unfair coin), so the expected work left is the same no matter how long
+// the search has already been running.  The ETA is just the expected work
+// divided by the current rate.
+
+// searchChunk is how many counter values a worker takes at a time.
+const searchChunk = 1 << 16
+
+// A MessageTemplate makes candidate messages for the forgery search, one for
+// each counter value.  Candidate must be safe to call from many goroutines at
+// once, and should give a different message for every n.
+type MessageTemplate interface {
+	Candidate(n uint64) []byte
+}
+
+// A PrefixTemplate is a MessageTemplate where every candidate starts with the
+// same bytes: Candidate(n) is FixedPrefix() followed by AppendTail(nil, n).
+// Search hashes the whole blocks of the prefix once per worker and only
+// hashes the rest for each candidate, with no allocations per candidate.
+type PrefixTemplate interface {
+	MessageTemplate
+	FixedPrefix() []byte
+	AppendTail(dst []byte, n uint64) []byte
+}
+
+// CounterTemplate makes messages of the form Prefix + n + Suffix, with n in
+// decimal.
+type CounterTemplate struct {
+	Prefix string
+	Suffix string
+}
+
+// Candidate returns the message for counter value n.
+func (self CounterTemplate) Candidate(n uint64) []byte {
+	b := make([]byte, 0, len(self.Prefix)+20+len(self.Suffix))
+	b = append(b, self.Prefix...)
+	return self.AppendTail(b, n)
+}
+
+// FixedPrefix returns the part that's the same for every candidate.
+func (self CounterTemplate) FixedPrefix() []byte {
+	return []byte(self.Prefix)
+}
+
+// AppendTail appends n and the suffix to dst.
+func (self CounterTemplate) AppendTail(dst []byte, n uint64) []byte {
+	dst = strconv.AppendUint(dst, n, 10)
+	return append(dst, self.Suffix...)
+}
+
+// The nonce alphabet is base64url, so nonces are printable and safe in file
+// names and URLs.  11 characters of 6 bits each covers all 64 bits of the
+// counter.
+const (
+	nonceAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	NonceWidth    = 11
+)
+
+// NonceTemplate makes messages of the form Prefix + nonce + Suffix, where the
+// nonce is n written as NonceWidth base64url characters, most significant
+// first.  Every candidate is the same length, and nonces sort in the same
+// order as their counters.
+type NonceTemplate struct {
+	Prefix string
+	Suffix string
+}
+
+// Candidate returns the message for counter value n.
+func (self NonceTemplate) Candidate(n uint64) []byte {
+	b := make([]byte, 0, len(self.Prefix)+NonceWidth+len(self.Suffix))
+	b = append(b, self.Prefix...)
+	return self.AppendTail(b, n)
+}
+
+// FixedPrefix returns the part that's the same for every candidate.
+func (self NonceTemplate) FixedPrefix() []byte {
+	return []byte(self.Prefix)
+}
+
+// AppendTail appends the nonce for n and the suffix to dst.
+func (self NonceTemplate) AppendTail(dst []byte, n uint64) []byte {
+	var nonce [NonceWidth]byte
+	for i := NonceWidth - 1; i >= 0; i-- {
+		nonce[i] = nonceAlphabet[n&63]
+		n >>= 6
+	}
+	dst = append(dst, nonce[:]...)
+	return append(dst, self.Suffix...)
+}
+
+// candidateHasher hashes candidates for one worker.  For a PrefixTemplate it
+// reuses one buffer for every candidate, and if the prefix is at least a
+// block long it saves the hash state after the prefix's whole blocks (the
+// midstate) and restores it for each candidate instead of starting over.
+type candidateHasher struct {
+	suite HashSuite
+	tmpl  MessageTemplate
+	pt    PrefixTemplate // nil if tmpl isn't one
+
+	h    hash.Hash
+	mid  []byte // marshaled midstate, or nil to hash from the start
+	rest []byte // prefix bytes after the midstate
+	buf  []byte
+	out  []byte
+}
+
+// newCandidateHasher sets up a hasher for the template.
+func newCandidateHasher(suite HashSuite, tmpl MessageTemplate) *candidateHasher {
+	c := &candidateHasher{suite: suite, tmpl: tmpl}
+	pt, ok := tmpl.(PrefixTemplate)
+	if !ok {
+		return c
+	}
+	c.pt = pt
+	c.rest = pt.FixedPrefix()
+	c.buf = make([]byte, 0, len(c.rest)+64)
+
+	h := suite.New()
+	whole := len(c.rest) / h.BlockSize() * h.BlockSize()
+	m, ok := h.(encoding.BinaryMarshaler)
+	_, canRestore := h.(encoding.BinaryUnmarshaler)
+	if whole == 0 || !ok || !canRestore {
+		return c
+	}
+	h.Write(c.rest[:whole])
+	mid, err := m.MarshalBinary()
+	if err != nil {
+		return c
+	}
+	c.h, c.mid, c.rest = h, mid, c.rest[whole:]
+	c.out = make([]byte, 0, h.Size())
+	return c
+}
+
+// sum returns the message hash of candidate n.
+func (self *candidateHasher) sum(n uint64) Message {
+	if self.pt == nil {
+		return Message(self.suite.Sum(self.tmpl.Candidate(n)))
+	}
+	self.buf = self.pt.AppendTail(append(self.buf[:0], self.rest...), n)
+	if self.mid == nil {
+		return Message(self.suite.Sum(self.buf))
+	}
+	self.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(self.mid)
+	self.h.Write(self.buf)
+	self.out = self.h.Sum(self.out[:0])
+	return Message(BlockFromByteSlice(self.out))
+}
+
+// SearchOptions controls a search.  The zero value is fine: one worker per
+// CPU, no progress reports and no checkpoint.
+type SearchOptions struct {
+	// Workers is the number of goroutines; 0 means one per CPU.
+	Workers int
+
+	// Progress, if set, gets called every ProgressInterval (default 1 second).
+	Progress         func(SearchProgress)
+	ProgressInterval time.Duration
+
+	// Checkpoint, if set, is a file to save the search position to every
+	// CheckpointInterval (default 30 seconds) and when the search is
+	// cancelled.  If it already exists, the search starts from there.  It's
+	// removed once the search succeeds.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+
+	// Start is the counter to start from when there's no checkpoint.  If
+	// everything below it is already known not to work, the result is the
+	// same as starting from 0, just a lot quicker.
+	Start uint64
+}
+
+// SearchProgress is what gets passed to SearchOptions.Progress.
+type SearchProgress struct {
+	Counter  uint64        // every counter value below this has been tried
+	Attempts uint64        // candidates tried since this run started
+	Elapsed  time.Duration // since this run started
+	Rate     float64       // attempts per second
+	ETA      time.Duration // expected time left; 0 if not known yet
+}
+
+// String formats the progress for printing.
+func (self SearchProgress) String() string {
+	eta := "unknown"
+	if self.ETA > 0 {
+		eta = self.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("counter %d, %d attempts in %s, %.0f/s, ETA %s",
+		self.Counter, self.Attempts, self.Elapsed.Round(time.Second), self.Rate, eta)
+}
+
+// Search hashes candidate messages from the template until it finds one that
+// can be forged, and returns it along with the forged signature.  The message
+// found is the one with the lowest counter from the start point on, so the
+// same search always gives the same answer.  Returns an error right away if
+// forgery is impossible, and returns ctx.Err() if ctx is cancelled first.
+func (self *ForgeryAnalysis) Search(
+	ctx context.Context, tmpl MessageTemplate, opts SearchOptions) (string, Signature, error) {
+
+	if math.IsInf(self.Log2Probability(), -1) {
+		return "", Signature{}, fmt.Errorf("forgery impossible: %s",
+			strings.TrimSpace(self.String()))
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	start := opts.Start
+	var saved uint64
+	var err error
+	if opts.Checkpoint != "" {
+		saved, err = self.loadCheckpoint(opts.Checkpoint, tmpl)
+		if err != nil {
+			return "", Signature{}, err
+		}
+		if saved != 0 {
+			start = saved
+		}
+	}
+
+	// next is the start of the next chunk to hand out, current[w] is the
+	// chunk worker w is on, and best is the lowest forgeable counter found
+	// so far
+	var next, attempts, best atomic.Uint64
+	next.Store(start)
+	best.Store(math.MaxUint64)
+	current := make([]atomic.Uint64, workers)
+	for w := range current {
+		current[w].Store(start)
+	}
+	frontier := func() uint64 {
+		low := current[0].Load()
+		for w := range current {
+			low = min(low, current[w].Load())
+		}
+		return low
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			hasher := newCandidateHasher(self.Pub.Suite, tmpl)
+			for searchCtx.Err() == nil {
+				c := next.Add(searchChunk) - searchChunk
+				if c >= best.Load() {
+					return
+				}
+				current[w].Store(c)
+				for n := c; n < c+searchChunk; n++ {
+					if self.CanSign(hasher.sum(n)) {
+						// keep the lowest; nothing past n in this chunk matters
+						for old := best.Load(); n < old; old = best.Load() {
+							if best.CompareAndSwap(old, n) {
+								break
+							}
+						}
+						break
+					}
+				}
+				attempts.Add(searchChunk)
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	progressInterval := opts.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = time.Second
+	}
+	checkpointInterval := opts.CheckpointInterval
+	if checkpointInterval <= 0 {
+		checkpointInterval = 30 * time.Second
+	}
+	progressTicker := time.NewTicker(progressInterval)
+	defer progressTicker.Stop()
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+	began := time.Now()
+
+	for {
+		select {
+		case <-done:
+			if searchCtx.Err() == nil {
+				// workers only stop on their own once they're past a find
+				if opts.Checkpoint != "" {
+					os.Remove(opts.Checkpoint)
+				}
+				msg := tmpl.Candidate(best.Load())
+				sig, err := self.ForgeSignature(Message(self.Pub.Suite.Sum(msg)))
+				return string(msg), sig, err
+			}
+			if opts.Checkpoint != "" {
+				err = self.saveCheckpoint(opts.Checkpoint, tmpl, frontier())
+				if err != nil {
+					return "", Signature{}, err
+				}
+			}
+			return "", Signature{}, ctx.Err()
+
+		case <-progressTicker.C:
+			if opts.Progress == nil {
+				continue
+			}
+			p := SearchProgress{
+				Counter:  frontier(),
+				Attempts: attempts.Load(),
+				Elapsed:  time.Since(began),
+			}
+			p.Rate = float64(p.Attempts) / p.Elapsed.Seconds()
+			if p.Rate > 0 {
+				p.ETA = time.Duration(self.ExpectedWork() / p.Rate * float64(time.Second))
+			}
+			opts.Progress(p)
+
+		case <-checkpointTicker.C:
+			if opts.Checkpoint == "" {
+				continue
+			}
+			err = self.saveCheckpoint(opts.Checkpoint, tmpl, frontier())
+			if err != nil {
+				return "", Signature{}, err
+			}
+		}
+	}
+}
+
+// --- Checkpoints
+
+// The checkpoint file is a few lines of text:
+//   forge checkpoint
+//   key <pubkey hash>
+//   template <hash of candidate 0>
+//   next <counter>
+// The key and template lines are there so that a checkpoint from a different
+// search doesn't get used by mistake.
+
+// checkpointIDs returns the key and template lines for this search.
+func (self *ForgeryAnalysis) checkpointIDs(tmpl MessageTemplate) (string, string) {
+	return self.Pub.Hash().ToHex(), self.Pub.Suite.Sum(tmpl.Candidate(0)).ToHex()
+}
+
+// saveCheckpoint writes the checkpoint file.
+func (self *ForgeryAnalysis) saveCheckpoint(path string, tmpl MessageTemplate, next uint64) error {
+	key, template := self.checkpointIDs(tmpl)
+	s := fmt.Sprintf("forge checkpoint\nkey %s\ntemplate %s\nnext %d\n", key, template, next)
+	return writeFileAtomic(path, []byte(s))
+}
+
+// loadCheckpoint reads the checkpoint file and returns the counter to start
+// from, or 0 if there's no checkpoint yet.
+func (self *ForgeryAnalysis) loadCheckpoint(path string, tmpl MessageTemplate) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(scanner.Text(), " ")
+		fields[k] = v
+	}
+	if scanner.Err() != nil {
+		return 0, scanner.Err()
+	}
+
+	key, template := self.checkpointIDs(tmpl)
+	if fields["forge"] != "checkpoint" {
+		return 0, fmt.Errorf("%s is not a forge checkpoint", path)
+	}
+	if fields["key"] != key || fields["template"] != template {
+		return 0, fmt.Errorf(
+			"checkpoint %s is for a different key or template; remove it to start over", path)
+	}
+	next, err := strconv.ParseUint(fields["next"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint %s: bad counter: %s", path, err.Error())
+	}
+	return next, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// providedAnalysis analyzes the pubkey and 4 signatures from signatures.go.
+func providedAnalysis(t *testing.T) *ForgeryAnalysis {
+	pub, err := HexToPubkey(hexPubkey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	var sigs []Signature
+	for i, h := range []string{hexSignature1, hexSignature2, hexSignature3, hexSignature4} {
+		sig, err := HexToSignature(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, GetMessageFromString(fmt.Sprintf("%d", i+1)))
+		sigs = append(sigs, sig)
+	}
+	a, err := AnalyzeSignatures(pub, msgs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// TestSearchCheckpoint starts the (long) search on the provided signatures,
+// cancels it, and checks that progress was reported and the checkpoint
+// saved.  Then it resumes and makes sure it starts from the checkpoint.
+func TestSearchCheckpoint(t *testing.T) {
+	a := providedAnalysis(t)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	tmpl := CounterTemplate{Prefix: "checkpoint test "}
+
+	var reports []SearchProgress
+	opts := SearchOptions{
+		Workers:          2,
+		Progress:         func(p SearchProgress) { reports = append(reports, p) },
+		ProgressInterval: 20 * time.Millisecond,
+		Checkpoint:       path,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, _, err := a.Search(ctx, tmpl, opts)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expect context.DeadlineExceeded", err)
+	}
+	if len(reports) == 0 {
+		t.Fatalf("no progress reports")
+	}
+	t.Log(reports[len(reports)-1])
+
+	saved, err := a.loadCheckpoint(path, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == 0 || saved%searchChunk != 0 {
+		t.Fatalf("checkpoint at %d, expect a nonzero multiple of %d", saved, searchChunk)
+	}
+
+	// resume; everything reported should be at or past the checkpoint
+	reports = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err = a.Search(ctx, tmpl, opts)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expect context.DeadlineExceeded", err)
+	}
+	for _, p := range reports {
+		if p.Counter < saved {
+			t.Fatalf("resumed search reported counter %d, before checkpoint %d",
+				p.Counter, saved)
+		}
+	}
+
+	// a different template can't use this checkpoint
+	_, _, err = a.Search(context.Background(), CounterTemplate{Prefix: "other "}, opts)
+	if err == nil {
+		t.Fatalf("Search used a checkpoint from a different template")
+	}
+}
+
+// TestSearchRemovesCheckpoint checks that a successful search cleans up its
+// checkpoint file.
+func TestSearchRemovesCheckpoint(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	var sigs []Signature
+	for i := 0; i < 12; i++ {
+		msg := GetMessageFromString(fmt.Sprintf("reused %d", i))
+		msgs = append(msgs, msg)
+		sigs = append(sigs, Sign(msg, sec))
+	}
+	a, err := AnalyzeSignatures(pub, msgs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	tmpl := CounterTemplate{Prefix: "forge "}
+	err = a.saveCheckpoint(path, tmpl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, sig, err := a.Search(context.Background(), tmpl, SearchOptions{Checkpoint: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(GetMessageFromString(msg), pub, sig) {
+		t.Fatalf("forged signature on %q doesn't verify", msg)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint still there after success: %v", err)
+	}
+}
+
+// TestNonceTemplate checks the nonce encoding: fixed width, printable, and
+// most significant character first.
+func TestNonceTemplate(t *testing.T) {
+	tmpl := NonceTemplate{Prefix: "p ", Suffix: " s"}
+	cases := map[uint64]string{
+		0:             "AAAAAAAAAAA",
+		63:            "AAAAAAAAAA_",
+		64:            "AAAAAAAAABA",
+		1<<63 | 1<<62: "MAAAAAAAAAA",
+		^uint64(0):    "P__________",
+	}
+	for n, nonce := range cases {
+		got := string(tmpl.Candidate(n))
+		if got != "p "+nonce+" s" {
+			t.Fatalf("candidate %d is %q, expect %q", n, got, "p "+nonce+" s")
+		}
+	}
+	if len(nonceAlphabet) != 64 {
+		t.Fatalf("nonce alphabet has %d characters", len(nonceAlphabet))
+	}
+}
+
+// TestCandidateHasher makes sure the buffer and midstate reuse give the same
+// hashes as hashing each candidate from scratch, for every suite, with
+// prefixes shorter and longer than a block.
+func TestCandidateHasher(t *testing.T) {
+	long := strings.Repeat("a long prefix, long enough to span a few blocks. ", 6)
+	templates := []MessageTemplate{
+		CounterTemplate{Prefix: "short ", Suffix: "!"},
+		CounterTemplate{Prefix: long},
+		NonceTemplate{Prefix: "short "},
+		NonceTemplate{Prefix: long, Suffix: " end"},
+	}
+	for _, suite := range []HashSuite{SuiteSHA256, SuiteSHA3, SuiteBLAKE2s} {
+		for _, tmpl := range templates {
+			h := newCandidateHasher(suite, tmpl)
+			if len(long) >= suite.New().BlockSize() && h.mid == nil &&
+				string(h.pt.FixedPrefix()) == long {
+				t.Fatalf("%s: no midstate for a %d byte prefix", suite, len(long))
+			}
+			for _, n := range []uint64{0, 1, 12345, 1 << 40, ^uint64(0)} {
+				expect := Message(suite.Sum(tmpl.Candidate(n)))
+				if got := h.sum(n); got != expect {
+					t.Fatalf("%s %T: candidate %d hashes to %x, expect %x",
+						suite, tmpl, n, got[:], expect[:])
+				}
+			}
+		}
+	}
+}
+
+// TestSearchDeterministic runs the same search with different numbers of
+// workers, and expects the same message every time.  With 6 signatures about
+// 8 bits are stuck, so there are forgeable messages in every chunk and the
+// workers really do race each other.
+func TestSearchDeterministic(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	var sigs []Signature
+	for i := 0; i < 6; i++ {
+		msg := GetMessageFromString(fmt.Sprintf("reused %d", i))
+		msgs = append(msgs, msg)
+		sigs = append(sigs, Sign(msg, sec))
+	}
+	a, err := AnalyzeSignatures(pub, msgs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := NonceTemplate{Prefix: "forge "}
+	for _, start := range []uint64{0, 5 * searchChunk} {
+		first, _, err := a.Search(context.Background(), tmpl,
+			SearchOptions{Workers: 1, Start: start})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first < string(tmpl.Candidate(start)) {
+			t.Fatalf("found %q, before start %d", first, start)
+		}
+		for _, workers := range []int{2, 4} {
+			msg, sig, err := a.Search(context.Background(), tmpl,
+				SearchOptions{Workers: workers, Start: start})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg != first {
+				t.Fatalf("%d workers found %q, 1 worker found %q", workers, msg, first)
+			}
+			if !Verify(GetMessageFromString(msg), pub, sig) {
+				t.Fatalf("forged signature on %q doesn't verify", msg)
+			}
+		}
+	}
+}
+
+// BenchmarkCandidateHasher measures the inner loop of Search with the
+// template Forge() uses, which hashes one block per candidate on top of the
+// midstate.
+func BenchmarkCandidateHasher(b *testing.B) {
+	a := &ForgeryAnalysis{}
+	h := newCandidateHasher(SuiteSHA256, forgeTemplate)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.CanSign(h.sum(uint64(i)))
+	}
+}
+
+// BenchmarkCandidateHasherNoMidstate is BenchmarkCandidateHasher hashing the
+// whole prefix for every candidate, which is two blocks.
+func BenchmarkCandidateHasherNoMidstate(b *testing.B) {
+	a := &ForgeryAnalysis{}
+	h := newCandidateHasher(SuiteSHA256, forgeTemplate)
+	h.mid, h.rest = nil, forgeTemplate.FixedPrefix()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.CanSign(h.sum(uint64(i)))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// Seed-derived Lamport keys.
+
+// GenerateKey() reads 512 random blocks, so the only way to keep the key is
+// to keep all 16KB of it.  Instead, every block of the secret key can come
+// from a PRF keyed with a single 32 byte seed.  Then the secret key can be
+// rebuilt whenever it's needed, and backing up the seed backs up the key.
+// One seed can make any number of keys by changing the key index.
+
+// The PRF is HMAC-SHA256 with the seed as the HMAC key, over
+//   "lamport" | key index (8 bytes) | row (1 byte, 0 or 1) | bit (2 bytes)
+// with the integers big endian.  The public key is computed from the secret
+// key in the usual way, so nothing about the key format changes.  Keys for
+// other hash suites use HMAC with that suite's hash instead.
+
+// seedPRFLabel is the first thing in every PRF input, so the same seed could
+// be used for other things without outputs colliding.
+const seedPRFLabel = "lamport"
+
+// GenerateSeed gets a random seed from the OS via crypto/rand.
+func GenerateSeed() (Block, error) {
+	var seed Block
+	_, err := rand.Read(seed[:])
+	return seed, err
+}
+
+// seedPRF gives the secret key block for a given key index, row and bit.
+func seedPRF(suite HashSuite, seed Block, index uint64, row byte, bit int) Block {
+	var in [len(seedPRFLabel) + 8 + 1 + 2]byte
+	n := copy(in[:], seedPRFLabel)
+	binary.BigEndian.PutUint64(in[n:], index)
+	in[n+8] = row
+	binary.BigEndian.PutUint16(in[n+9:], uint16(bit))
+
+	mac := hmac.New(suite.New, seed[:])
+	mac.Write(in[:])
+	return BlockFromByteSlice(mac.Sum(nil))
+}
+
+// DeriveKey makes the keypair for a seed and key index.  Same inputs always
+// give the same keys.
+func DeriveKey(seed Block, index uint64) (SecretKey, PublicKey) {
+	return DeriveKeyWithSuite(SuiteSHA256, seed, index)
+}
+
+// DeriveKeyWithSuite is DeriveKey for keys using the given hash suite.  The
+// suite must be valid.
+func DeriveKeyWithSuite(suite HashSuite, seed Block, index uint64) (SecretKey, PublicKey) {
+	sec := SecretKey{Suite: suite}
+	for i := 0; i < 256; i++ {
+		sec.ZeroPre[i] = seedPRF(suite, seed, index, 0, i)
+		sec.OnePre[i] = seedPRF(suite, seed, index, 1, i)
+	}
+	return sec, sec.PublicKey()
+}
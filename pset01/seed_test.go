@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestDeriveKey checks that the same seed and index give the same key, that
+// a different index gives a different key, and that derived keys sign.
+func TestDeriveKey(t *testing.T) {
+	seed, err := GenerateSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec, pub := DeriveKey(seed, 7)
+
+	// back up and restore the seed
+	seed2, err := HexToBlock(seed.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec2, pub2 := DeriveKey(seed2, 7)
+	if sec != sec2 || pub.ToHex() != pub2.ToHex() {
+		t.Fatalf("DeriveKey gave different keys for the same seed and index")
+	}
+
+	_, pub3 := DeriveKey(seed, 8)
+	if pub3 == pub {
+		t.Fatalf("DeriveKey gave the same key for different indexes")
+	}
+
+	msg := GetMessageFromString("seed")
+	if !Verify(msg, pub, Sign(msg, sec)) {
+		t.Fatalf("Verify returned false, expected true")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+)
+
+// SPHINCS-style stateless signatures.
+
+// A Merkle signer (merkle.go) can sign many messages, but it has to remember
+// which leaf comes next, and losing track means reusing a one-time key.
+// SPHINCS gets rid of that state.  The leaf is picked from the message
+// itself, out of so many leaves that two messages landing on the same one is
+// rare, and the key at that leaf is a few-time key (HORST) so that even when
+// it does happen, nothing breaks.
+
+// Nobody can build a tree with 2^60 leaves, so it's a hypertree: d layers of
+// small Merkle trees, each of height h/d.  The leaves of every tree are WOTS
+// keys.  The single tree on the top layer has the public key as its root.
+// Each WOTS key on one layer signs the root of a tree on the layer below, and
+// at the bottom each WOTS key signs the root of a HORST key, which signs the
+// message.  None of those trees exist until they're needed: every secret
+// block comes from a PRF over the secret seed and where the block sits, so
+// signing only builds the d trees on the path to the chosen leaf.
+
+// Signing msg:
+//   R = HMAC-SHA256(prf key, "sphincs R" | msg)
+//   sha512(R | msg) gives the digest HORST signs (first 32 bytes), and the
+//   leaf index (next 8 bytes, big endian, cut down to h bits)
+// R is deterministic so the same message always gets the same signature, but
+// unpredictable without the prf key so nobody can aim messages at a leaf.
+
+// Leaf index idx picks the HORST key idx.  On layer L the tree is idx >>
+// ((L+1)*h/d) and the leaf within it is the next h/d bits down.  Layer 0 is
+// the bottom.
+
+// The WOTS and HORST hashing here is the same plain sha256 as everywhere else
+// in this pset, without the masks and addresses of real SPHINCS, so security
+// relies on sha256 collision resistance.
+
+// SPHINCSParams are the parameters for a SPHINCS key.  Layers must divide
+// Height evenly.
+type SPHINCSParams struct {
+	Height int        // total hypertree height: 2^Height HORST keys
+	Layers int        // number of layers in the hypertree
+	W      int        // Winternitz parameter for the WOTS keys
+	HORST  HORSParams // few-time keys at the bottom
+}
+
+// MaxSPHINCSHeight is the tallest hypertree allowed; the leaf index has to
+// fit in a uint64.
+const MaxSPHINCSHeight = 64
+
+var (
+	// SPHINCSTiny is for tests: 16 leaves and a weak HORST.  Signs in about
+	// a millisecond.  Not secure at all.
+	SPHINCSTiny = SPHINCSParams{Height: 4, Layers: 2, W: 16, HORST: HORSParams{K: 8, T: 32}}
+
+	// SPHINCSSmall has 2^12 leaves and a HORST key that holds up for a few
+	// uses, which is enough for a few hundred signatures.
+	SPHINCSSmall = SPHINCSParams{Height: 12, Layers: 3, W: 16, HORST: HORSParams{K: 16, T: 1 << 12}}
+
+	// SPHINCSFull is shaped like SPHINCS-256: 12 layers of height 5 trees,
+	// with 2^16 HORST keys.  Since messages here are 256 bits, HORST only
+	// gets k=16 instead of 32.  Signatures are about 36KB.
+	SPHINCSFull = SPHINCSParams{Height: 60, Layers: 12, W: 16, HORST: DefaultHORSParams}
+)
+
+// --- Types
+
+// A SPHINCSSecretKey is two seeds: one for every secret block, and one for
+// picking R.
+type SPHINCSSecretKey struct {
+	Params SPHINCSParams
+	Seed   Block
+	PRFKey Block
+}
+
+// A SPHINCSPublicKey is the root of the top tree.
+type SPHINCSPublicKey struct {
+	Params SPHINCSParams
+	Root   Block
+}
+
+// A SPHINCSLayer is the WOTS signature from one layer of the hypertree, and
+// the auth path from that WOTS key's leaf to the root of its tree.
+type SPHINCSLayer struct {
+	WOTS WOTSSignature
+	Path []Block
+}
+
+// A SPHINCSSignature is R, the leaf index it gives, the HORST signature on
+// the message, and a WOTS signature and auth path for each layer from the
+// bottom up.
+type SPHINCSSignature struct {
+	Params SPHINCSParams
+	R      Block
+	Index  uint64
+	HORST  HORSTSignature
+	Layers []SPHINCSLayer
+}
+
+// --- Parameters
+
+// treeHeight returns the height of each tree in the hypertree, or an error
+// if the parameters can't be used.
+func (self SPHINCSParams) treeHeight() (int, error) {
+	if self.Height < 1 || self.Height > MaxSPHINCSHeight {
+		return 0, fmt.Errorf("SPHINCS height %d invalid, expect 1 to %d",
+			self.Height, MaxSPHINCSHeight)
+	}
+	if self.Layers < 1 || self.Height%self.Layers != 0 {
+		return 0, fmt.Errorf("SPHINCS layers %d invalid, expect a divisor of height %d",
+			self.Layers, self.Height)
+	}
+	h := self.Height / self.Layers
+	if h > MaxMerkleHeight {
+		return 0, fmt.Errorf("SPHINCS tree height %d too big, max %d", h, MaxMerkleHeight)
+	}
+	if _, err := wotsLogW(self.W); err != nil {
+		return 0, err
+	}
+	if _, err := self.HORST.logT(); err != nil {
+		return 0, err
+	}
+	return h, nil
+}
+
+// Valid returns true if the parameters can be used.
+func (self SPHINCSParams) Valid() bool {
+	_, err := self.treeHeight()
+	return err == nil
+}
+
+// --- Key derivation
+
+// The PRF is HMAC-SHA256 with the seed as the key, over
+//   "sphincs" | kind (1 byte) | layer (1 byte) | tree (8 bytes) |
+//   leaf (4 bytes) | block (4 bytes)
+// where kind is 'w' for WOTS chains and 'h' for HORST blocks.  HORST keys use
+// layer 0, the leaf index as the tree, and leaf 0.
+
+const sphincsPRFLabel = "sphincs"
+
+// sphincsPRF gives the secret block at an address.
+func sphincsPRF(seed Block, kind byte, layer int, tree uint64, leaf uint32, i int) Block {
+	var in [len(sphincsPRFLabel) + 1 + 1 + 8 + 4 + 4]byte
+	n := copy(in[:], sphincsPRFLabel)
+	in[n] = kind
+	in[n+1] = byte(layer)
+	binary.BigEndian.PutUint64(in[n+2:], tree)
+	binary.BigEndian.PutUint32(in[n+10:], leaf)
+	binary.BigEndian.PutUint32(in[n+14:], uint32(i))
+
+	mac := hmac.New(sha256.New, seed[:])
+	mac.Write(in[:])
+	return BlockFromByteSlice(mac.Sum(nil))
+}
+
+// wotsKey derives the WOTS key at a leaf of a tree on some layer.
+func (self SPHINCSSecretKey) wotsKey(layer int, tree uint64, leaf uint32) WOTSSecretKey {
+	len1, len2, _ := wotsLengths(self.Params.W)
+	sec := WOTSSecretKey{W: self.Params.W, Pre: make([]Block, len1+len2)}
+	for i := range sec.Pre {
+		sec.Pre[i] = sphincsPRF(self.Seed, 'w', layer, tree, leaf, i)
+	}
+	return sec
+}
+
+// horstKey derives the HORST key for a leaf index.
+func (self SPHINCSSecretKey) horstKey(idx uint64) HORSSecretKey {
+	sec := HORSSecretKey{Params: self.Params.HORST, Pre: make([]Block, self.Params.HORST.T)}
+	for i := range sec.Pre {
+		sec.Pre[i] = sphincsPRF(self.Seed, 'h', 0, idx, 0, i)
+	}
+	return sec
+}
+
+// treeLevels builds a whole tree of WOTS keys on one layer.
+func (self SPHINCSSecretKey) treeLevels(layer int, tree uint64) [][]Block {
+	h, _ := self.Params.treeHeight()
+	leaves := make([]Block, 1<<uint(h))
+	for leaf := range leaves {
+		leaves[leaf] = self.wotsKey(layer, tree, uint32(leaf)).WOTSPublicKey().Leaf()
+	}
+	return merkleLevels(leaves)
+}
+
+// sphincsAddress returns which tree on a layer, and which leaf in it, leads
+// down to the leaf index.
+func sphincsAddress(idx uint64, layer, h int) (uint64, uint32) {
+	shift := uint(layer * h)
+	tree := idx >> shift >> uint(h)
+	leaf := uint32(idx >> shift & (1<<uint(h) - 1))
+	return tree, leaf
+}
+
+// sphincsDigest hashes R and the message into the leaf index and the digest
+// for HORST to sign.
+func sphincsDigest(r Block, msg Message, height int) (uint64, Message) {
+	var in [64]byte
+	copy(in[:32], r[:])
+	copy(in[32:], msg[:])
+	sum := sha512.Sum512(in[:])
+
+	idx := binary.BigEndian.Uint64(sum[32:40])
+	if height < 64 {
+		idx &= 1<<uint(height) - 1
+	}
+	return idx, Message(BlockFromByteSlice(sum[:32]))
+}
+
+// --- Functions
+
+// GenerateSPHINCSKey makes a SPHINCS keypair.  The seeds come from
+// crypto/rand; making the public key means building the top tree.
+func GenerateSPHINCSKey(params SPHINCSParams) (SPHINCSSecretKey, SPHINCSPublicKey, error) {
+	sec := SPHINCSSecretKey{Params: params}
+	if _, err := params.treeHeight(); err != nil {
+		return sec, SPHINCSPublicKey{Params: params}, err
+	}
+	_, err := rand.Read(sec.Seed[:])
+	if err != nil {
+		return sec, SPHINCSPublicKey{Params: params}, err
+	}
+	_, err = rand.Read(sec.PRFKey[:])
+	if err != nil {
+		return sec, SPHINCSPublicKey{Params: params}, err
+	}
+	return sec, sec.PublicKey(), nil
+}
+
+// PublicKey builds the top tree and returns its root.
+func (self SPHINCSSecretKey) PublicKey() SPHINCSPublicKey {
+	levels := self.treeLevels(self.Params.Layers-1, 0)
+	return SPHINCSPublicKey{Params: self.Params, Root: levels[len(levels)-1][0]}
+}
+
+// SignSPHINCS signs a message.  No state: the same key can sign as many
+// messages as you like, from as many places at once as you like.
+func SignSPHINCS(msg Message, sec SPHINCSSecretKey) SPHINCSSignature {
+	sig := SPHINCSSignature{Params: sec.Params}
+	h, _ := sec.Params.treeHeight()
+
+	mac := hmac.New(sha256.New, sec.PRFKey[:])
+	mac.Write([]byte("sphincs R"))
+	mac.Write(msg[:])
+	sig.R = BlockFromByteSlice(mac.Sum(nil))
+
+	idx, digest := sphincsDigest(sig.R, msg, sec.Params.Height)
+	sig.Index = idx
+	horst := sec.horstKey(idx)
+	sig.HORST = SignHORST(digest, horst)
+
+	// each layer signs the root of the tree below it
+	node := horst.HORSTPublicKey().Root
+	for layer := 0; layer < sec.Params.Layers; layer++ {
+		tree, leaf := sphincsAddress(idx, layer, h)
+		levels := sec.treeLevels(layer, tree)
+		sig.Layers = append(sig.Layers, SPHINCSLayer{
+			WOTS: signWOTS(Message(node), sec.wotsKey(layer, tree, leaf)),
+			Path: merkleAuthPath(levels, uint64(leaf)),
+		})
+		node = levels[h][0]
+	}
+	return sig
+}
+
+// VerifySPHINCS works its way up from the HORST signature: each layer's WOTS
+// signature gives a WOTS public key, which with the auth path gives the root
+// of that layer's tree, which is what the next layer up signed.  The top
+// root has to be the public key.
+func VerifySPHINCS(msg Message, pub SPHINCSPublicKey, sig SPHINCSSignature) bool {
+	if sig.Params != pub.Params {
+		return false
+	}
+	h, err := pub.Params.treeHeight()
+	if err != nil || len(sig.Layers) != pub.Params.Layers {
+		return false
+	}
+	idx, digest := sphincsDigest(sig.R, msg, pub.Params.Height)
+	if idx != sig.Index || sig.HORST.Params != pub.Params.HORST {
+		return false
+	}
+
+	node, ok := horstRoot(digest, sig.HORST)
+	if !ok {
+		return false
+	}
+	for layer, l := range sig.Layers {
+		if l.WOTS.W != pub.Params.W || len(l.Path) != h {
+			return false
+		}
+		ends, ok := wotsChainEnds(Message(node), l.WOTS)
+		if !ok {
+			return false
+		}
+		_, leaf := sphincsAddress(idx, layer, h)
+		wotsPub := WOTSPublicKey{W: l.WOTS.W, Hash: ends}
+		node = merkleRootFromPath(wotsPub.Leaf(), uint64(leaf), l.Path)
+	}
+	return node == pub.Root
+}
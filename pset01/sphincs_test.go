@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSPHINCSGoodSig signs a bunch of messages with one key, with no state
+// kept anywhere, and checks they all verify.
+func TestSPHINCSGoodSig(t *testing.T) {
+	for _, params := range []SPHINCSParams{SPHINCSTiny, SPHINCSSmall} {
+		sec, pub, err := GenerateSPHINCSKey(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			msg := GetMessageFromString(fmt.Sprintf("stateless %d", i))
+			sig := SignSPHINCS(msg, sec)
+			if !VerifySPHINCS(msg, pub, sig) {
+				t.Fatalf("%+v: VerifySPHINCS returned false, expected true", params)
+			}
+		}
+	}
+}
+
+// TestSPHINCSFull does one signature with the realistic parameters.
+func TestSPHINCSFull(t *testing.T) {
+	sec, pub, err := GenerateSPHINCSKey(SPHINCSFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("full size")
+	sig := SignSPHINCS(msg, sec)
+	if !VerifySPHINCS(msg, pub, sig) {
+		t.Fatalf("VerifySPHINCS returned false, expected true")
+	}
+	blocks := 1 + len(sig.HORST.Pre)
+	for _, path := range sig.HORST.Paths {
+		blocks += len(path)
+	}
+	for _, l := range sig.Layers {
+		blocks += len(l.WOTS.Chain) + len(l.Path)
+	}
+	t.Logf("full size signature: %d blocks, %d bytes", blocks, blocks*32)
+}
+
+// TestSPHINCSDeterministic checks that the same message gets the same
+// signature, and different messages (almost always) get different leaves.
+func TestSPHINCSDeterministic(t *testing.T) {
+	sec, _, err := GenerateSPHINCSKey(SPHINCSSmall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("same")
+	a, b := SignSPHINCS(msg, sec), SignSPHINCS(msg, sec)
+	if a.R != b.R || a.Index != b.Index || a.Layers[0].WOTS.Chain[0] != b.Layers[0].WOTS.Chain[0] {
+		t.Fatalf("two signatures on the same message differ")
+	}
+	c := SignSPHINCS(GetMessageFromString("different"), sec)
+	if c.R == a.R {
+		t.Fatalf("different messages got the same R")
+	}
+}
+
+// TestSPHINCSBadSig tries a different message, a changed WOTS chain, a
+// changed index, and a different key.
+func TestSPHINCSBadSig(t *testing.T) {
+	sec, pub, err := GenerateSPHINCSKey(SPHINCSTiny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("bad")
+	sig := SignSPHINCS(msg, sec)
+
+	if VerifySPHINCS(GetMessageFromString("worse"), pub, sig) {
+		t.Fatalf("VerifySPHINCS returned true for a different message")
+	}
+
+	sig.Layers[1].WOTS.Chain[0][0] ^= 1
+	if VerifySPHINCS(msg, pub, sig) {
+		t.Fatalf("VerifySPHINCS returned true for a changed WOTS chain")
+	}
+
+	sig = SignSPHINCS(msg, sec)
+	sig.Index ^= 1
+	if VerifySPHINCS(msg, pub, sig) {
+		t.Fatalf("VerifySPHINCS returned true for a changed index")
+	}
+
+	_, other, err := GenerateSPHINCSKey(SPHINCSTiny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifySPHINCS(msg, other, SignSPHINCS(msg, sec)) {
+		t.Fatalf("VerifySPHINCS returned true with a different key")
+	}
+
+	if _, _, err = GenerateSPHINCSKey(SPHINCSParams{Height: 10, Layers: 3, W: 16,
+		HORST: SPHINCSTiny.HORST}); err == nil {
+		t.Fatalf("GenerateSPHINCSKey should fail when layers don't divide height")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Public key fingerprints, and a trust store mapping names to them.
+
+// A public key is 16KB, or 32KB of hex, and nobody is going to compare two of
+// those by eye.  A Fingerprint is the first 160 bits of PublicKey.Hash(),
+// written in base32 in groups of 4:
+//   KZ4G-6AQB-TLNU-QH7D-2BIS-4MEO-WZ3V-5XRA
+// which is short enough to read out over the phone.  160 bits is the same as
+// a PGP fingerprint; finding a second key with the same fingerprint means
+// 2^160 hashes, and the keys themselves are only as strong as 2^128 or so
+// against a quantum attacker anyway.
+
+// The trust store is a text file with one line per signer:
+//   <name> <fingerprint> [revoked]
+// Blank lines and lines starting with # are ignored.  Names can't have
+// whitespace in them.  The public keys themselves go in a directory next to
+// it, <store>.keys/<fingerprint>.pub, so verify can be given a signer's name
+// and find the key; each key is checked against its fingerprint when it's
+// read, so editing a file in there doesn't get a different key trusted.
+
+// Revoking a signer keeps the line around, marked revoked, so that the key
+// can't be added back under the same or a different name by mistake.  It's
+// the key that's revoked, not the name: each key can only be added once, and
+// if a hand-edited store lists a key under two names, revoking either one
+// revokes both.
+
+// FingerprintSize is how many bytes of PublicKey.Hash() are in a fingerprint.
+const FingerprintSize = 20
+
+// fingerprintEncoding is unpadded base32; 20 bytes is exactly 32 characters.
+var fingerprintEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var (
+	ErrUnknownSigner = errors.New("signer not in trust store")
+	ErrRevokedSigner = errors.New("signer's key has been revoked")
+)
+
+// A Fingerprint is a short name for a public key.
+type Fingerprint [FingerprintSize]byte
+
+// Fingerprint returns the fingerprint of the public key.
+func (self PublicKey) Fingerprint() Fingerprint {
+	var fp Fingerprint
+	id := self.Hash()
+	copy(fp[:], id[:])
+	return fp
+}
+
+// String gives the fingerprint in base32 groups of 4, split with dashes.
+func (self Fingerprint) String() string {
+	s := fingerprintEncoding.EncodeToString(self[:])
+	var groups []string
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, "-")
+}
+
+// ParseFingerprint reads a fingerprint back.  Dashes, spaces and case don't
+// matter, so whatever someone copied down should work.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	var fp Fingerprint
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	b, err := fingerprintEncoding.DecodeString(s)
+	if err != nil || len(b) != FingerprintSize {
+		return fp, fmt.Errorf("bad fingerprint %q", s)
+	}
+	copy(fp[:], b)
+	return fp, nil
+}
+
+// A TrustEntry is one line of the trust store.
+type TrustEntry struct {
+	Name        string
+	Fingerprint Fingerprint
+	Revoked     bool
+}
+
+// A TrustStore maps names to public keys.  Unlike KeyStore there's no lock
+// file: the trust store is edited by hand or by "pset01 trust", not by
+// several signers at once.
+type TrustStore struct {
+	path    string
+	entries map[string]*TrustEntry
+}
+
+// LoadTrustStore reads the trust store at path.  A missing file is an empty
+// store; it's created by the first Add().
+func LoadTrustStore(path string) (*TrustStore, error) {
+	ts := &TrustStore{path: path, entries: make(map[string]*TrustEntry)}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ts, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "revoked") {
+			return nil, fmt.Errorf("trust store %s line %d: expect <name> <fingerprint> [revoked]",
+				path, line)
+		}
+		fp, err := ParseFingerprint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("trust store %s line %d: %s", path, line, err.Error())
+		}
+		if ts.entries[fields[0]] != nil {
+			return nil, fmt.Errorf("trust store %s line %d: %s listed twice",
+				path, line, fields[0])
+		}
+		ts.entries[fields[0]] = &TrustEntry{
+			Name: fields[0], Fingerprint: fp, Revoked: len(fields) == 3,
+		}
+	}
+	return ts, scanner.Err()
+}
+
+// Entries lists everything in the store, sorted by name.
+func (self *TrustStore) Entries() []TrustEntry {
+	var list []TrustEntry
+	for _, e := range self.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Lookup finds a signer by name.  Unknown signers are errors, and so are
+// signers whose key has been revoked under any name.
+func (self *TrustStore) Lookup(name string) (TrustEntry, error) {
+	e := self.entries[name]
+	if e == nil {
+		return TrustEntry{}, fmt.Errorf("%s: %w", name, ErrUnknownSigner)
+	}
+	for _, other := range self.entries {
+		if other.Fingerprint == e.Fingerprint && other.Revoked {
+			return *e, fmt.Errorf("%s: %w", name, ErrRevokedSigner)
+		}
+	}
+	return *e, nil
+}
+
+// PublicKey looks up a signer and reads their public key from the keys
+// directory, checking that it matches the fingerprint.
+func (self *TrustStore) PublicKey(name string) (PublicKey, error) {
+	e, err := self.Lookup(name)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	keyPath := self.keyPath(e.Fingerprint)
+	s, err := readTrimmed(keyPath)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	pub, err := parsePubkey(s)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("%s: %s", keyPath, err.Error())
+	}
+	if pub.Fingerprint() != e.Fingerprint {
+		return PublicKey{}, fmt.Errorf("%s: key has fingerprint %s, expect %s",
+			keyPath, pub.Fingerprint(), e.Fingerprint)
+	}
+	return pub, nil
+}
+
+// Add trusts pub under name, and saves the store.  The name and the key both
+// have to be new; a revoked key is still in the store, so it can't come back.
+func (self *TrustStore) Add(name string, pub PublicKey) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") || strings.HasPrefix(name, "#") {
+		return fmt.Errorf("bad signer name %q", name)
+	}
+	if self.entries[name] != nil {
+		return fmt.Errorf("%s is already in the trust store", name)
+	}
+	fp := pub.Fingerprint()
+	for _, e := range self.entries {
+		if e.Fingerprint == fp && e.Revoked {
+			return fmt.Errorf("key %s was revoked as %s", fp, e.Name)
+		}
+		if e.Fingerprint == fp {
+			return fmt.Errorf("key %s is already in the trust store as %s", fp, e.Name)
+		}
+	}
+
+	err := os.MkdirAll(self.path+".keys", 0755)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(self.keyPath(fp), []byte(pub.ToHex()+"\n"))
+	if err != nil {
+		return err
+	}
+	self.entries[name] = &TrustEntry{Name: name, Fingerprint: fp}
+	return self.save()
+}
+
+// Revoke marks a signer's key as revoked, and saves the store.
+func (self *TrustStore) Revoke(name string) error {
+	e := self.entries[name]
+	if e == nil {
+		return fmt.Errorf("%s: %w", name, ErrUnknownSigner)
+	}
+	e.Revoked = true
+	return self.save()
+}
+
+// keyPath is where the public key with this fingerprint is kept.
+func (self *TrustStore) keyPath(fp Fingerprint) string {
+	return filepath.Join(self.path+".keys", fingerprintEncoding.EncodeToString(fp[:])+".pub")
+}
+
+// save writes the store out with writeFileAtomic.
+func (self *TrustStore) save() error {
+	var buf bytes.Buffer
+	buf.WriteString("# name fingerprint [revoked]\n")
+	for _, e := range self.Entries() {
+		fmt.Fprintf(&buf, "%s %s", e.Name, e.Fingerprint)
+		if e.Revoked {
+			buf.WriteString(" revoked")
+		}
+		buf.WriteString("\n")
+	}
+	return writeFileAtomic(self.path, buf.Bytes())
+}
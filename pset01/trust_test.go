@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFingerprint checks the fingerprint format and that sloppy copies of it
+// still parse.
+func TestFingerprint(t *testing.T) {
+	_, pub, _ := fuzzSeedKey(SuiteSHA256)
+	_, pub3, _ := fuzzSeedKey(SuiteSHA3)
+	fp := pub.Fingerprint()
+	if fp == pub3.Fingerprint() {
+		t.Fatalf("two keys with the same fingerprint")
+	}
+
+	s := fp.String()
+	if len(s) != 39 || strings.Count(s, "-") != 7 {
+		t.Fatalf("fingerprint %s, expect 8 groups of 4", s)
+	}
+	for _, in := range []string{s, strings.ToLower(s), strings.ReplaceAll(s, "-", " ")} {
+		got, err := ParseFingerprint(in)
+		if err != nil || got != fp {
+			t.Fatalf("ParseFingerprint(%q) = %s, %v", in, got, err)
+		}
+	}
+	if _, err := ParseFingerprint(s[:30]); err == nil {
+		t.Fatalf("short fingerprint parsed")
+	}
+}
+
+// TestTrustStore adds, looks up, revokes and reloads signers.
+func TestTrustStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust")
+	_, alice, _ := fuzzSeedKey(SuiteSHA256)
+	_, bob, _ := fuzzSeedKey(SuiteSHA3)
+
+	ts, err := LoadTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Add("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Add("bob", bob); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Add("alice", bob); err == nil {
+		t.Fatalf("added alice twice")
+	}
+	if err = ts.Add("carol smith", bob); err == nil {
+		t.Fatalf("added a name with a space")
+	}
+	if err = ts.Add("alice2", alice); err == nil {
+		t.Fatalf("added alice's key under a second name")
+	}
+	if err = ts.Revoke("bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err = LoadTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.Entries()) != 2 {
+		t.Fatalf("got %d entries after reload, expect 2", len(ts.Entries()))
+	}
+	pub, err := ts.PublicKey("alice")
+	if err != nil || pub != alice {
+		t.Fatalf("alice's key: %v", err)
+	}
+	if _, err = ts.PublicKey("bob"); !errors.Is(err, ErrRevokedSigner) {
+		t.Fatalf("bob: got %v, expect ErrRevokedSigner", err)
+	}
+	if _, err = ts.PublicKey("mallory"); !errors.Is(err, ErrUnknownSigner) {
+		t.Fatalf("mallory: got %v, expect ErrUnknownSigner", err)
+	}
+	// a revoked key can't come back under a new name
+	if err = ts.Add("robert", bob); err == nil {
+		t.Fatalf("re-added a revoked key")
+	}
+
+	// a hand-edited store with bob's key under another name: revoking bob
+	// revokes the key, whatever it's called
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "robert %s\n", bob.Fingerprint())
+	f.Close()
+	if ts, err = LoadTrustStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ts.Lookup("robert"); !errors.Is(err, ErrRevokedSigner) {
+		t.Fatalf("robert: got %v, expect ErrRevokedSigner", err)
+	}
+
+	// swap a different key into alice's key file
+	if err = os.WriteFile(ts.keyPath(alice.Fingerprint()), []byte(bob.ToHex()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ts.PublicKey("alice"); err == nil {
+		t.Fatalf("PublicKey returned a key that doesn't match the fingerprint")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Detailed verification (why a signature failed, not just that it did), and
+// batch verification.
+
+// Verify() stops at the first bad block and returns false, which doesn't
+// help much with figuring out what went wrong.  VerifyDetailed() checks every
+// block and also tries each one against the row the message didn't ask for.
+// That tells apart the usual problems:
+//   - a few blocks match neither row: the signature got corrupted
+//   - the failed blocks all match the other row: it's a good signature, but
+//     on a different message (or the message was hashed differently)
+//   - nothing matches anything: wrong public key
+
+// A VerificationError describes a signature that didn't verify.  If the
+// signature couldn't be checked at all (suites don't match, say) Reason says
+// why and FirstBit is -1.  Otherwise every block was checked and the fields
+// describe what failed.
+type VerificationError struct {
+	Reason string
+
+	FirstBit    int  // first bit whose block didn't match, or -1
+	ExpectedRow int  // the row the message needs at FirstBit, 0 or 1
+	OtherRow    bool // the block at FirstBit matched the other row instead
+
+	Failed    int // bits that didn't match, out of 256
+	OtherRows int // of those, how many matched the other row
+}
+
+// WrongMessage returns true if every block that failed matched the other
+// row, meaning the signature is fine but was made for a different message.
+func (self *VerificationError) WrongMessage() bool {
+	return self.Failed > 0 && self.OtherRows == self.Failed
+}
+
+// Error describes the failure in a line.
+func (self *VerificationError) Error() string {
+	if self.FirstBit < 0 {
+		return self.Reason
+	}
+	got := "a block matching neither row"
+	if self.OtherRow {
+		got = fmt.Sprintf("the row %d preimage instead", 1-self.ExpectedRow)
+	}
+	s := fmt.Sprintf("%d of 256 bits failed; first is bit %d, which needs the row %d preimage but has %s",
+		self.Failed, self.FirstBit, self.ExpectedRow, got)
+	switch {
+	case self.WrongMessage():
+		s += "; looks like a signature on a different message"
+	case self.OtherRows == 0 && self.Failed == 256:
+		s += "; looks like the wrong public key"
+	}
+	return s
+}
+
+// VerifyDetailed checks a signature like Verify(), and returns nil if it's
+// good or a *VerificationError saying what's wrong with it.
+func VerifyDetailed(msg Message, pub PublicKey, sig Signature) error {
+	if sig.Suite != pub.Suite {
+		return &VerificationError{
+			Reason:   fmt.Sprintf("signature uses %s, pubkey uses %s", sig.Suite, pub.Suite),
+			FirstBit: -1,
+		}
+	}
+	if !pub.Suite.Valid() {
+		return &VerificationError{
+			Reason:   fmt.Sprintf("invalid hash suite %d", uint8(pub.Suite)),
+			FirstBit: -1,
+		}
+	}
+
+	verr := &VerificationError{FirstBit: -1}
+	for i, block := range sig.Preimage {
+		expected, other := pub.ZeroHash[i], pub.OneHash[i]
+		row := int(msg[i/8] >> (7 - (i % 8)) & 0x01)
+		if row == 1 {
+			expected, other = other, expected
+		}
+
+		hash := block.HashWith(pub.Suite)
+		if hash == expected {
+			continue
+		}
+		verr.Failed++
+		otherRow := hash == other
+		if otherRow {
+			verr.OtherRows++
+		}
+		if verr.FirstBit < 0 {
+			verr.FirstBit = i
+			verr.ExpectedRow = row
+			verr.OtherRow = otherRow
+		}
+	}
+	if verr.Failed == 0 {
+		return nil
+	}
+	return verr
+}
+
+// --- Batches
+
+// VerifyBatch checks a lot of signatures at once: sigs[i] on msgs[i] with
+// pubs[i].  The work is spread over one goroutine per CPU, a few signatures
+// at a time, and the results come back in the same order.  Returns an error
+// if the slices aren't all the same length.
+func VerifyBatch(msgs []Message, pubs []PublicKey, sigs []Signature) ([]bool, error) {
+	if len(msgs) != len(pubs) || len(msgs) != len(sigs) {
+		return nil, fmt.Errorf("got %d messages, %d pubkeys and %d signatures",
+			len(msgs), len(pubs), len(sigs))
+	}
+	results := make([]bool, len(msgs))
+
+	// small enough that the work evens out, big enough that the atomic add
+	// doesn't matter
+	const batchChunk = 8
+	workers := min(runtime.NumCPU(), (len(msgs)+batchChunk-1)/batchChunk)
+
+	var next atomic.Uint64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(batchChunk) - batchChunk)
+				if start >= len(msgs) {
+					return
+				}
+				for i := start; i < min(start+batchChunk, len(msgs)); i++ {
+					results[i] = verifyBlocks(&msgs[i], &pubs[i], &sigs[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// verifyBlocks is Verify() without the bytes.Equal calls: each hash is
+// compared as a Block.  Everything is passed by pointer since a PublicKey is
+// 16KB.
+func verifyBlocks(msg *Message, pub *PublicKey, sig *Signature) bool {
+	if sig.Suite != pub.Suite || !pub.Suite.Valid() {
+		return false
+	}
+	for i := range sig.Preimage {
+		expected := &pub.ZeroHash[i]
+		if msg[i/8]>>(7-(i%8))&0x01 == 0x01 {
+			expected = &pub.OneHash[i]
+		}
+		if pub.Suite.Sum(sig.Preimage[i][:]) != *expected {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestVerifyDetailed goes through the kinds of failure VerifyDetailed is
+// supposed to tell apart, and checks it agrees with Verify.
+func TestVerifyDetailed(t *testing.T) {
+	sec, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := GetMessageFromString("detailed")
+	other := GetMessageFromString("something else")
+	sig := Sign(msg, sec)
+
+	if err = VerifyDetailed(msg, pub, sig); err != nil {
+		t.Fatalf("good signature: %v", err)
+	}
+
+	detail := func(msg Message, pub PublicKey, sig Signature) *VerificationError {
+		t.Helper()
+		err := VerifyDetailed(msg, pub, sig)
+		if Verify(msg, pub, sig) != (err == nil) {
+			t.Fatalf("Verify and VerifyDetailed disagree: %v", err)
+		}
+		var verr *VerificationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("got %v, expect a *VerificationError", err)
+		}
+		return verr
+	}
+
+	// signature on a different message: every failure is on the other row,
+	// and the first one is the first bit where the messages differ
+	verr := detail(other, pub, sig)
+	first := -1
+	differ := 0
+	for i := 0; i < 256; i++ {
+		a, b := msg[i/8]>>(7-(i%8))&0x01, other[i/8]>>(7-(i%8))&0x01
+		if a != b {
+			differ++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if !verr.WrongMessage() || verr.Failed != differ || verr.FirstBit != first || !verr.OtherRow {
+		t.Fatalf("different message: got %+v, expect %d failed from bit %d", verr, differ, first)
+	}
+	if verr.ExpectedRow != int(other[first/8]>>(7-(first%8))&0x01) {
+		t.Fatalf("different message: expected row %d is wrong", verr.ExpectedRow)
+	}
+	if !strings.Contains(verr.Error(), "different message") {
+		t.Fatalf("different message: error %q doesn't say so", verr.Error())
+	}
+
+	// one corrupted block
+	bad := sig
+	bad.Preimage[17][3] ^= 0x40
+	verr = detail(msg, pub, bad)
+	if verr.Failed != 1 || verr.FirstBit != 17 || verr.OtherRow || verr.WrongMessage() {
+		t.Fatalf("corrupted block: got %+v", verr)
+	}
+
+	// a different key entirely
+	_, pub2, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verr = detail(msg, pub2, sig)
+	if verr.Failed != 256 || verr.OtherRows != 0 || verr.FirstBit != 0 {
+		t.Fatalf("wrong key: got %+v", verr)
+	}
+
+	// suites don't match; nothing gets checked
+	bad = sig
+	bad.Suite = SuiteSHA3
+	verr = detail(msg, pub, bad)
+	if verr.FirstBit != -1 || verr.Reason == "" {
+		t.Fatalf("suite mismatch: got %+v", verr)
+	}
+}
+
+// TestVerifyBatch mixes good and bad signatures and checks every result
+// matches Verify.
+func TestVerifyBatch(t *testing.T) {
+	var msgs []Message
+	var pubs []PublicKey
+	var sigs []Signature
+	for i := 0; i < 50; i++ {
+		sec, pub, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := GetMessageFromString(fmt.Sprintf("batch %d", i))
+		sig := Sign(msg, sec)
+		switch i % 5 {
+		case 1:
+			sig.Preimage[i] = sig.Preimage[i].Hash()
+		case 3:
+			msg = GetMessageFromString("not what was signed")
+		}
+		msgs, pubs, sigs = append(msgs, msg), append(pubs, pub), append(sigs, sig)
+	}
+
+	results, err := VerifyBatch(msgs, pubs, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range results {
+		if ok != Verify(msgs[i], pubs[i], sigs[i]) || ok != (i%5 != 1 && i%5 != 3) {
+			t.Fatalf("signature %d: VerifyBatch says %v", i, ok)
+		}
+	}
+
+	if _, err = VerifyBatch(msgs, pubs[1:], sigs); err == nil {
+		t.Fatalf("VerifyBatch should fail with mismatched lengths")
+	}
+	if results, err = VerifyBatch(nil, nil, nil); err != nil || len(results) != 0 {
+		t.Fatalf("empty batch: got %v, %v", results, err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Winternitz one-time signatures.
+
+// Lamport reveals one of two preimages for every bit of the message, which
+// makes for 16KB public keys and 8KB signatures.  Winternitz instead reads the
+// message log2(w) bits at a time, and for each of those digits keeps a single
+// hash chain of length w-1.  The secret key is the start of every chain, the
+// public key is the end of every chain, and signing a digit d means revealing
+// the block d steps down its chain.  Anyone can hash the rest of the way to
+// the end and compare with the public key.
+
+// On its own that would let someone who sees a signature push digits up and
+// sign a "bigger" message, so a checksum of (w-1-d) over all the message digits
+// gets signed as well.  Making a message digit bigger makes the checksum
+// smaller, and nobody can walk a chain backwards.
+
+// Bigger w means fewer chains (smaller keys and signatures) but more hashing:
+//   w=4:   133 chains, 4.3KB  keys, up to 3 hashes per chain
+//   w=16:   67 chains, 2.1KB  keys, up to 15 hashes per chain
+//   w=256:  34 chains, 1.1KB  keys, up to 255 hashes per chain
+// (w=2 also works, but is a worse Lamport.)
+
+// The chains are plain iterated Block.Hash() without the per-step masks of
+// WOTS+, so security rests on sha256 collision resistance rather than just
+// second preimage resistance.  That's plenty for what we're doing here.
+
+// Same ordering rules as Lamport: digits are read from the message MSB first,
+// and the checksum digits come after the message digits, also MSB first.
+
+// --- Types
+
+// A WOTSSecretKey is the start of every hash chain.
+type WOTSSecretKey struct {
+	W   int
+	Pre []Block
+}
+
+// A WOTSPublicKey is the end of every hash chain, w-1 hashes after the start.
+type WOTSPublicKey struct {
+	W    int
+	Hash []Block
+}
+
+// A WOTSSignature has one block from somewhere in the middle of each chain.
+type WOTSSignature struct {
+	W     int
+	Chain []Block
+}
+
+// --- Parameters
+
+// wotsLogW returns log2(w), or an error if w isn't something we can use.
+// The digit size has to divide evenly into a byte so that digits never
+// straddle bytes.
+func wotsLogW(w int) (uint, error) {
+	switch w {
+	case 2:
+		return 1, nil
+	case 4:
+		return 2, nil
+	case 16:
+		return 4, nil
+	case 256:
+		return 8, nil
+	}
+	return 0, fmt.Errorf("Winternitz parameter w=%d invalid, expect 2, 4, 16 or 256", w)
+}
+
+// wotsLengths returns the number of message digits (len1) and checksum digits
+// (len2) for a given w.  Total number of chains is len1+len2.
+func wotsLengths(w int) (len1, len2 int, err error) {
+	logW, err := wotsLogW(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	len1 = 256 / int(logW)
+	// the biggest checksum is len1*(w-1); count how many base w digits that is
+	for maxSum := len1 * (w - 1); maxSum > 0; maxSum /= w {
+		len2++
+	}
+	return len1, len2, nil
+}
+
+// wotsDigits splits the message into base w digits and appends the checksum
+// digits.  Assumes w is valid.
+func wotsDigits(msg Message, w int) []int {
+	logW, _ := wotsLogW(w)
+	len1, len2, _ := wotsLengths(w)
+
+	digits := make([]int, len1+len2)
+	perByte := 8 / int(logW)
+	mask := byte(w - 1)
+	checksum := 0
+	for i := 0; i < len1; i++ {
+		shift := uint(8 - int(logW)*(i%perByte+1))
+		digits[i] = int(msg[i/perByte] >> shift & mask)
+		checksum += w - 1 - digits[i]
+	}
+	// checksum is written big endian, like everything else
+	for i := len1 + len2 - 1; i >= len1; i-- {
+		digits[i] = checksum % w
+		checksum /= w
+	}
+	return digits
+}
+
+// wotsChain hashes the block the given number of times.
+func wotsChain(b Block, steps int) Block {
+	for i := 0; i < steps; i++ {
+		b = b.Hash()
+	}
+	return b
+}
+
+// --- Functions
+
+// GenerateWOTSKey makes a Winternitz keypair with parameter w.  Like
+// GenerateKey(), randomness comes from crypto/rand.
+func GenerateWOTSKey(w int) (WOTSSecretKey, WOTSPublicKey, error) {
+	sec := WOTSSecretKey{W: w}
+	pub := WOTSPublicKey{W: w}
+
+	len1, len2, err := wotsLengths(w)
+	if err != nil {
+		return sec, pub, err
+	}
+
+	sec.Pre = make([]Block, len1+len2)
+	for i := range sec.Pre {
+		_, err = rand.Read(sec.Pre[i][:])
+		if err != nil {
+			return sec, pub, err
+		}
+	}
+	return sec, sec.WOTSPublicKey(), nil
+}
+
+// WOTSPublicKey hashes every chain all the way to the end to get the public
+// key.
+func (self WOTSSecretKey) WOTSPublicKey() WOTSPublicKey {
+	pub := WOTSPublicKey{W: self.W, Hash: make([]Block, len(self.Pre))}
+	for i, b := range self.Pre {
+		pub.Hash[i] = wotsChain(b, self.W-1)
+	}
+	return pub
+}
+
+// Leaf returns the sha256 of every chain end in order, so that a whole WOTS
+// public key can be a Merkle leaf.
+func (self WOTSPublicKey) Leaf() Block {
+	return sha256.Sum256(appendBlocks(nil, self.Hash))
+}
+
+// SignWOTS signs a message with a Winternitz secret key.  Each chain is walked
+// down as many steps as its digit.  Returns an error if w is invalid or the
+// key has the wrong number of chains for it.
+func SignWOTS(msg Message, sec WOTSSecretKey) (WOTSSignature, error) {
+	len1, len2, err := wotsLengths(sec.W)
+	if err != nil {
+		return WOTSSignature{}, err
+	}
+	if len(sec.Pre) != len1+len2 {
+		return WOTSSignature{}, fmt.Errorf("WOTS secret key has %d chains, expect %d for w=%d",
+			len(sec.Pre), len1+len2, sec.W)
+	}
+	return signWOTS(msg, sec), nil
+}
+
+// signWOTS is SignWOTS for keys that are known to be the right shape, like
+// the ones SPHINCS derives.
+func signWOTS(msg Message, sec WOTSSecretKey) WOTSSignature {
+	sig := WOTSSignature{W: sec.W}
+
+	digits := wotsDigits(msg, sec.W)
+	sig.Chain = make([]Block, len(digits))
+	for i, d := range digits {
+		sig.Chain[i] = wotsChain(sec.Pre[i], d)
+	}
+	return sig
+}
+
+// VerifyWOTS finishes off every chain in the signature and checks that it
+// ends up at the public key.
+func VerifyWOTS(msg Message, pub WOTSPublicKey, sig WOTSSignature) bool {
+	if sig.W != pub.W {
+		return false
+	}
+	len1, len2, err := wotsLengths(pub.W)
+	if err != nil {
+		return false
+	}
+	if len(pub.Hash) != len1+len2 {
+		return false
+	}
+	ends, ok := wotsChainEnds(msg, sig)
+	if !ok {
+		return false
+	}
+	for i := range ends {
+		if ends[i] != pub.Hash[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wotsChainEnds finishes off every chain in the signature, which gives the
+// public key it must have come from.  Returns false if w is invalid or the
+// signature is the wrong length for it.
+func wotsChainEnds(msg Message, sig WOTSSignature) ([]Block, bool) {
+	len1, len2, err := wotsLengths(sig.W)
+	if err != nil || len(sig.Chain) != len1+len2 {
+		return nil, false
+	}
+	ends := make([]Block, len(sig.Chain))
+	for i, d := range wotsDigits(msg, sig.W) {
+		ends[i] = wotsChain(sig.Chain[i], sig.W-1-d)
+	}
+	return ends, true
+}
+
+// --- Hex encoding
+
+// The hex format is 1 byte of log2(w), then every block in order.  Since w
+// determines the number of blocks, the length can be checked after reading
+// the first byte.
+
+// wotsToHex encodes w and the blocks.  Assumes w is valid.
+func wotsToHex(w int, blocks []Block) string {
+	logW, _ := wotsLogW(w)
+	return hex.EncodeToString(appendBlocks([]byte{byte(logW)}, blocks))
+}
+
+// hexToWOTS decodes the output of wotsToHex.  what is used in error messages.
+func hexToWOTS(s string, what string) (int, []Block, error) {
+	if len(s) < 2 {
+		return 0, nil, fmt.Errorf("%s string %d characters, too short", what, len(s))
+	}
+	logW, err := hex.DecodeString(s[:2])
+	if err != nil {
+		return 0, nil, err
+	}
+	if logW[0] == 0 || logW[0] > 8 {
+		return 0, nil, fmt.Errorf("%s log2(w) %d invalid", what, logW[0])
+	}
+	w := 1 << logW[0]
+	len1, len2, err := wotsLengths(w)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	expectedLength := 2 + (len1+len2)*64 // 1 byte w, 64 hex char per block
+	if len(s) != expectedLength {
+		return 0, nil, fmt.Errorf(
+			"%s string %d characters, expect %d", what, len(s), expectedLength)
+	}
+
+	bts, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return 0, nil, err
+	}
+	blocks := make([]Block, len1+len2)
+	err = readBlocks(blocks, bts)
+	if err != nil {
+		return 0, nil, err
+	}
+	return w, blocks, nil
+}
+
+// ToHex gives a hex string for a WOTSPublicKey. no newline at the end
+func (self WOTSPublicKey) ToHex() string {
+	return wotsToHex(self.W, self.Hash)
+}
+
+// HexToWOTSPubkey takes a string from WOTSPublicKey.ToHex() and turns it into
+// a pubkey.  Returns an error if w is invalid, there are non hex characters,
+// or the length doesn't match w.
+func HexToWOTSPubkey(s string) (WOTSPublicKey, error) {
+	var p WOTSPublicKey
+	w, blocks, err := hexToWOTS(s, "WOTS pubkey")
+	if err != nil {
+		return p, err
+	}
+	p.W, p.Hash = w, blocks
+	return p, nil
+}
+
+// ToHex returns a hex string of a WOTS signature
+func (self WOTSSignature) ToHex() string {
+	return wotsToHex(self.W, self.Chain)
+}
+
+// HexToWOTSSignature is the same idea as HexToWOTSPubkey, and since WOTS
+// signatures have as many blocks as the pubkey, it's the same size too.
+func HexToWOTSSignature(s string) (WOTSSignature, error) {
+	var sig WOTSSignature
+	w, blocks, err := hexToWOTS(s, "WOTS signature")
+	if err != nil {
+		return sig, err
+	}
+	sig.W, sig.Chain = w, blocks
+	return sig, nil
+}
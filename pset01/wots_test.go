@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestWOTSGoodSig signs and verifies with every allowed w, and checks that
+// the key sizes come out as expected.
+func TestWOTSGoodSig(t *testing.T) {
+	chains := map[int]int{2: 265, 4: 133, 16: 67, 256: 34}
+
+	msg := GetMessageFromString("good")
+	for w, n := range chains {
+		sec, pub, err := GenerateWOTSKey(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pub.Hash) != n {
+			t.Fatalf("w=%d: got %d chains, expect %d", w, len(pub.Hash), n)
+		}
+
+		sig, err := SignWOTS(msg, sec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyWOTS(msg, pub, sig) {
+			t.Fatalf("w=%d: VerifyWOTS returned false, expected true", w)
+		}
+	}
+}
+
+// TestWOTSBadSig tries the signature on a different message, with a chain
+// pushed one step further along, and on a message with one digit raised to
+// match, and signs with bad keys.  All of them should fail.
+func TestWOTSBadSig(t *testing.T) {
+	msg := GetMessageFromString("bad")
+	sec, pub, err := GenerateWOTSKey(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignWOTS(msg, sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if VerifyWOTS(GetMessageFromString("worse"), pub, sig) {
+		t.Fatalf("VerifyWOTS returned true for a different message")
+	}
+
+	// pushing a chain along without changing the message is a plain mismatch
+	bad := WOTSSignature{W: sig.W, Chain: append([]Block(nil), sig.Chain...)}
+	bad.Chain[3] = bad.Chain[3].Hash()
+	if VerifyWOTS(msg, pub, bad) {
+		t.Fatalf("VerifyWOTS returned true, expected false")
+	}
+
+	// raising a message digit and pushing its chain along to match is what
+	// the checksum prevents: every message chain still checks out, and only
+	// the checksum chains, which would have to go backwards, don't
+	digits := wotsDigits(msg, 16)
+	i := 0
+	for digits[i] == 15 {
+		i++
+	}
+	raised := msg
+	raised[i/2] += byte(1) << (4 * uint(1-i%2))
+	forged := WOTSSignature{W: sig.W, Chain: append([]Block(nil), sig.Chain...)}
+	forged.Chain[i] = forged.Chain[i].Hash()
+	ends, _ := wotsChainEnds(raised, forged)
+	for j := 0; j < 64; j++ {
+		if ends[j] != pub.Hash[j] {
+			t.Fatalf("message chain %d doesn't match after raising digit %d", j, i)
+		}
+	}
+	if VerifyWOTS(raised, pub, forged) {
+		t.Fatalf("VerifyWOTS accepted a raised digit; the checksum should catch it")
+	}
+
+	if _, _, err = GenerateWOTSKey(8); err == nil {
+		t.Fatalf("GenerateWOTSKey(8) should fail")
+	}
+
+	// bad keys are errors, not panics
+	if _, err = SignWOTS(msg, WOTSSecretKey{}); err == nil {
+		t.Fatalf("SignWOTS accepted a zero key")
+	}
+	if _, err = SignWOTS(msg, WOTSSecretKey{W: 16, Pre: sec.Pre[:10]}); err == nil {
+		t.Fatalf("SignWOTS accepted a key with too few chains")
+	}
+}
+
+// TestWOTSHex round trips keys and signatures through hex.
+func TestWOTSHex(t *testing.T) {
+	msg := GetMessageFromString("hex")
+	sec, pub, err := GenerateWOTSKey(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignWOTS(msg, sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub2, err := HexToWOTSPubkey(pub.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig2, err := HexToWOTSSignature(sig.ToHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyWOTS(msg, pub2, sig2) {
+		t.Fatalf("VerifyWOTS after hex round trip returned false")
+	}
+
+	// w=16 header with a w=4 body is the wrong length
+	bad := "04" + pub.ToHex()[2:]
+	if _, err = HexToWOTSPubkey(bad); err == nil {
+		t.Fatalf("HexToWOTSPubkey accepted a length mismatch")
+	}
+}
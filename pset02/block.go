@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Blocks, targets, and turning them into strings and back.
+
+// This file is the same in pset02/ and pset02/server/, since the miner and
+// the server are separate programs.  Change both together.
+
+// There are three block formats.  The original one is
+//   prevhash name nonce
+// and has no version number; it's version 0 here.  Version 1 adds a
+// timestamp, so the server can tell how fast blocks are coming in and adjust
+// the difficulty:
+//   1 timestamp prevhash name nonce
+// with the timestamp in unix seconds.  Version 2 adds the block's height
+// too, so a block says where in the chain it goes:
+//   2 height timestamp prevhash name nonce
+// The version comes first so it's easy to tell them apart, and the hash is
+// still just sha256 of the string.  Old blocks stay valid, so the existing
+// chain doesn't have to be mined again.
+
+// Targets: a block hash, read as a 256 bit big endian number, has to be at
+// or below the target.  The old rule of 33 leading zero bits is the target
+// 2^223 - 1, but a target can be any number, so the difficulty can move in
+// steps smaller than doubling.
+
+const (
+	BlockVersionLegacy = 0 // prevhash name nonce
+	BlockVersionTime   = 1 // 1 timestamp prevhash name nonce
+	BlockVersionHeight = 2 // 2 height timestamp prevhash name nonce
+
+	// max length of a block string, not counting the newline
+	maxLegacyBlockLength    = 100
+	maxVersionedBlockLength = 128
+)
+
+// A hash is a sha256 hash, as in pset01
+type Hash [32]byte
+
+// ToString gives you a hex string of the hash
+func (self Hash) ToString() string {
+	return fmt.Sprintf("%x", self)
+}
+
+// Blocks are what make the chain in this pset; different than just a 32 byte array
+// from last time.  Has a previous block hash, a name and a nonce, and for
+// version 1 and up, a timestamp, and for version 2 and up, a height.
+type Block struct {
+	Version   uint32
+	Height    uint64 // 0 before version 2
+	Timestamp int64  // unix seconds; 0 for legacy blocks
+	PrevHash  Hash
+	Name      string
+	Nonce     string
+}
+
+// ToString turns a block into an ascii string which can be sent over the
+// network or printed to the screen.
+func (self Block) ToString() string {
+	switch {
+	case self.Version == BlockVersionLegacy:
+		return fmt.Sprintf("%x %s %s", self.PrevHash, self.Name, self.Nonce)
+	case self.Version < BlockVersionHeight:
+		return fmt.Sprintf("%d %d %x %s %s",
+			self.Version, self.Timestamp, self.PrevHash, self.Name, self.Nonce)
+	}
+	return fmt.Sprintf("%d %d %d %x %s %s",
+		self.Version, self.Height, self.Timestamp, self.PrevHash, self.Name, self.Nonce)
+}
+
+// Hash returns the sha256 hash of the block.  Hopefully starts with zeros!
+func (self Block) Hash() Hash {
+	return sha256.Sum256([]byte(self.ToString()))
+}
+
+// Block rules.  Every block has to follow these, on top of having enough
+// work and fitting on the chain:
+//   length     at most 100 characters for legacy blocks, 128 for versioned
+//   fields     3 elements separated by single spaces for legacy blocks, 5
+//              for version 1, 6 for version 2
+//   version    1 or 2, if there is one
+//   number     version, height and timestamp in plain decimal: no sign, no
+//              leading zeros, so each block has only one way to write it
+//   height     at least 1
+//   timestamp  at least 1
+//   prevhash   exactly 64 lowercase hex characters
+//   name       at least 1 character, all from the base64 charset; legacy
+//              blocks already on the chain only need printable ASCII
+//              with no spaces
+//   nonce      same as name
+// The base64 charset is A-Z, a-z, 0-9, + and /.  The existing chain was
+// mined before the charset, with names like zhejyan@microsoft.com, so
+// Validate lets legacy blocks off with printable ASCII, and the server uses
+// ValidateSubmission for blocks it's sent, which holds every block to the
+// charset.  Only the chain loaded from disk is grandfathered.  The number
+// rule matters
+// because the hash is of the string the server writes back out; if "01"
+// parsed as 1, the block would hash differently than when it was mined.
+
+// base64Charset is what names and nonces are allowed to use.
+const base64Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// A BlockError is a block breaking one of the rules above.
+type BlockError struct {
+	Rule   string // which rule, like "name" or "length"
+	Detail string
+}
+
+func (self *BlockError) Error() string {
+	return fmt.Sprintf("block rule %q broken: %s", self.Rule, self.Detail)
+}
+
+// blockErrorf makes a BlockError for a rule.
+func blockErrorf(rule, format string, args ...interface{}) error {
+	return &BlockError{Rule: rule, Detail: fmt.Sprintf(format, args...)}
+}
+
+// ValidateName checks a name (or nonce) against the name rule.
+func ValidateName(name string) error {
+	return validateCharset("name", name)
+}
+
+// validateCharset checks that s isn't empty and is all base64 characters.
+func validateCharset(rule, s string) error {
+	if s == "" {
+		return blockErrorf(rule, "%s is empty", rule)
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(base64Charset, s[i]) < 0 {
+			return blockErrorf(rule, "%s has %q at position %d; only A-Z a-z 0-9 + / allowed",
+				rule, s[i], i)
+		}
+	}
+	return nil
+}
+
+// Validate checks a block against all the block rules.  BlockFromString
+// already does this; it's for blocks made some other way, like by the miner
+// before it sends one.
+func (self Block) Validate() error {
+	if self.Version > BlockVersionHeight {
+		return blockErrorf("version", "unknown block version %d", self.Version)
+	}
+	s := self.ToString()
+	maxLength := maxVersionedBlockLength
+	if self.Version == BlockVersionLegacy {
+		maxLength = maxLegacyBlockLength
+	}
+	if len(s) > maxLength {
+		return blockErrorf("length", "block is %d characters, max %d", len(s), maxLength)
+	}
+	if self.Version >= BlockVersionHeight && self.Height == 0 {
+		return blockErrorf("height", "height is 0")
+	}
+	if self.Version >= BlockVersionTime && self.Timestamp <= 0 {
+		return blockErrorf("timestamp", "timestamp %d is not positive", self.Timestamp)
+	}
+	check := validateCharset
+	if self.Version == BlockVersionLegacy {
+		check = validateLegacyField
+	}
+	err := check("name", self.Name)
+	if err != nil {
+		return err
+	}
+	return check("nonce", self.Nonce)
+}
+
+// ValidateSubmission checks a block the way the server checks blocks sent
+// to it: all the block rules, with the base64 charset for legacy blocks too.
+func (self Block) ValidateSubmission() error {
+	err := self.Validate()
+	if err != nil || self.Version != BlockVersionLegacy {
+		return err
+	}
+	err = validateCharset("name", self.Name)
+	if err != nil {
+		return err
+	}
+	return validateCharset("nonce", self.Nonce)
+}
+
+// validateLegacyField checks a legacy block's name or nonce: not empty, and
+// printable ASCII with no spaces.
+func validateLegacyField(rule, s string) error {
+	if s == "" {
+		return blockErrorf(rule, "%s is empty", rule)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return blockErrorf(rule, "%s has %q at position %d; only printable ASCII allowed",
+				rule, s[i], i)
+		}
+	}
+	return nil
+}
+
+// parseBlockNumber reads a version, height or timestamp, which has to be
+// plain decimal.
+func parseBlockNumber(rule, s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 63)
+	if err != nil || strconv.FormatUint(n, 10) != s {
+		return 0, blockErrorf("number", "%s %q is not a plain decimal number", rule, s)
+	}
+	return n, nil
+}
+
+// BlockFromString takes in a string and converts it to a block, if possible.
+// The block has to follow all the block rules.
+func BlockFromString(s string) (Block, error) {
+	var bl Block
+
+	// remove trailing newline if there; the blocks don't include newlines, but
+	// when transmitted over TCP there's a newline to signal end of block
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+
+	// check string length.  Validate checks again with the right limit for
+	// the version, but this stops a huge string being split up.
+	if len(s) > maxVersionedBlockLength {
+		return bl, blockErrorf("length", "block is %d characters, max %d",
+			len(s), maxVersionedBlockLength)
+	}
+
+	// split into substrings via spaces: 3 for legacy blocks, more for
+	// versioned ones
+	subStrings := strings.Split(s, " ")
+	if len(subStrings) != 3 {
+		// versioned blocks start with a number; anything else is a legacy
+		// block with the wrong number of elements
+		if subStrings[0] == "" || strings.Trim(subStrings[0], "0123456789") != "" {
+			return bl, blockErrorf("fields", "got %d elements, expect 3", len(subStrings))
+		}
+		version, err := parseBlockNumber("version", subStrings[0])
+		if err != nil {
+			return bl, err
+		}
+		if version < BlockVersionTime || version > BlockVersionHeight {
+			return bl, blockErrorf("version", "unknown block version %d", version)
+		}
+		bl.Version = uint32(version)
+		// version 1 has 5 elements, version 2 has 6
+		expect := 5
+		if bl.Version >= BlockVersionHeight {
+			expect = 6
+		}
+		if len(subStrings) != expect {
+			return bl, blockErrorf("fields", "got %d elements, expect %d for version %d",
+				len(subStrings), expect, bl.Version)
+		}
+		subStrings = subStrings[1:]
+
+		if bl.Version >= BlockVersionHeight {
+			bl.Height, err = parseBlockNumber("height", subStrings[0])
+			if err != nil {
+				return bl, err
+			}
+			subStrings = subStrings[1:]
+		}
+		timestamp, err := parseBlockNumber("timestamp", subStrings[0])
+		if err != nil {
+			return bl, err
+		}
+		bl.Timestamp = int64(timestamp)
+		subStrings = subStrings[1:]
+	}
+
+	// lowercase only, so there's just one way to write each hash
+	prevHex := subStrings[0]
+	if len(prevHex) != 64 || strings.Trim(prevHex, "0123456789abcdef") != "" {
+		return bl, blockErrorf("prevhash", "prevhash %q is not 64 lowercase hex characters", prevHex)
+	}
+	hex.Decode(bl.PrevHash[:], []byte(prevHex))
+
+	bl.Name = subStrings[1]
+	bl.Nonce = subStrings[2]
+
+	return bl, bl.Validate()
+}
+
+// TargetFromBits gives the target for a hash with this many leading zero
+// bits: 2^(256-bits) - 1.
+func TargetFromBits(bits uint8) *big.Int {
+	t := new(big.Int).Lsh(big.NewInt(1), 256-uint(bits))
+	return t.Sub(t, big.NewInt(1))
+}
+
+// TargetBits is the difficulty of a target as a number of zero bits, like
+// 33.0 for the old rule.  Can be fractional.
+func TargetBits(target *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(new(big.Int).Add(target, big.NewInt(1))).Float64()
+	return 256 - math.Log2(f)
+}
+
+// TargetToString gives a target as 64 hex characters.
+func TargetToString(target *big.Int) string {
+	return hex.EncodeToString(target.FillBytes(make([]byte, 32)))
+}
+
+// TargetFromString reads a target written by TargetToString.
+func TargetFromString(s string) (*big.Int, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("bad target %q, expect 64 hex characters", s)
+	}
+	t := new(big.Int).SetBytes(b)
+	if t.Sign() == 0 {
+		return nil, fmt.Errorf("target is zero")
+	}
+	return t, nil
+}
+
+// CheckWork checks if there's enough work: the hash has to be at or below
+// the target.
+func CheckWork(bl Block, target *big.Int) bool {
+	h := bl.Hash()
+	return bytes.Compare(h[:], target.FillBytes(make([]byte, 32))) <= 0
+}
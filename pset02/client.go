@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"math/big"
 	"net"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -24,12 +27,29 @@ var (
 // Can return an error if the connection doesn't work or the server is sending
 // invalid data that doesn't look like a block.
 func GetTipFromServer() (Block, error) {
-	var bl Block
+	info, err := GetTipInfoFromServer()
+	return info.Tip, err
+}
+
+// TipInfo is what the server sends back for a tip request.
+type TipInfo struct {
+	Tip        Block
+	Target     *big.Int // target for the next block
+	Height     uint64   // height of the tip
+	HaveHeight bool     // false if the server didn't say
+}
+
+// GetTipInfoFromServer gets the tip, and the TARGET and HEIGHT lines the
+// server sends after it.  Older servers don't send them; then the target is
+// the original 33 bits and the height isn't known.
+func GetTipInfoFromServer() (TipInfo, error) {
+	info := TipInfo{Target: TargetFromBits(33)}
 
 	connection, err := net.Dial("tcp", serverHostname)
 	if err != nil {
-		return bl, err
+		return info, err
 	}
+	defer connection.Close()
 	fmt.Printf("connected to server %s\n", connection.RemoteAddr().String())
 
 	// send tip request to server
@@ -38,7 +58,7 @@ func GetTipFromServer() (Block, error) {
 	// write to server, error out if needed
 	_, err = connection.Write(sendbytes)
 	if err != nil {
-		return bl, err
+		return info, err
 	}
 
 	// setup to read response
@@ -47,20 +67,40 @@ func GetTipFromServer() (Block, error) {
 	// read from TCP
 	blockLine, err := bufReader.ReadBytes('\n')
 	if err != nil {
-		return bl, err
+		return info, err
 	}
 
 	fmt.Printf("read from server:\n%s\n", string(blockLine))
 
 	// convert to block
-	bl, err = BlockFromString(string(blockLine))
+	info.Tip, err = BlockFromString(string(blockLine))
 	if err != nil {
-		return bl, err
+		return info, err
 	}
 
-	// return block
+	// then the target and height, if the server sends them
+	for i := 0; i < 2; i++ {
+		line, err := bufReader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return info, fmt.Errorf("unexpected line from server: %q", line)
+		}
+		switch fields[0] {
+		case "TARGET":
+			info.Target, err = TargetFromString(fields[1])
+		case "HEIGHT":
+			info.Height, err = strconv.ParseUint(fields[1], 10, 64)
+			info.HaveHeight = err == nil
+		}
+		if err != nil {
+			return info, err
+		}
+	}
 
-	return bl, nil
+	return info, nil
 }
 
 // SendBlockToServer connects to the server and sends a block.  The server won't
@@ -94,3 +134,184 @@ func SendBlockToServer(bl Block) (msg string, err error) {
 
 	return string(ResponseLine), connection.Close()
 }
+
+// The functions above each make a new connection and send one line.  The
+// server also has framed commands (see server/protocol.go) that work over one
+// connection that stays open, so a client can fetch blocks by hash or height,
+// or sync the whole chain.  A ServerConn is one of those connections.
+
+// ServerError is an ERR response from the server.
+type ServerError struct {
+	Code    int // 400 bad request, 404 not found, 413 too many blocks
+	Message string
+}
+
+func (self *ServerError) Error() string {
+	return fmt.Sprintf("server error %d: %s", self.Code, self.Message)
+}
+
+// ServerConn is a framed protocol connection to the server.
+type ServerConn struct {
+	connection net.Conn
+	bufReader  *bufio.Reader
+}
+
+// ChainBlock is a block from the server, with where it is in the chain.
+type ChainBlock struct {
+	Block  Block
+	Height uint64
+	Main   bool // on the main chain, rather than a side branch
+}
+
+// DialServer opens a framed protocol connection.
+func DialServer() (*ServerConn, error) {
+	connection, err := net.Dial("tcp", serverHostname)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerConn{connection: connection, bufReader: bufio.NewReader(connection)}, nil
+}
+
+// Close sends QUIT and hangs up.
+func (self *ServerConn) Close() error {
+	self.Command("QUIT")
+	return self.connection.Close()
+}
+
+// Command sends one command and reads the response frame.  Returns the
+// frame's type and lines, or a *ServerError if the server sent ERR.
+func (self *ServerConn) Command(cmd string) (string, []string, error) {
+	_, err := fmt.Fprintf(self.connection, "%s\n", cmd)
+	if err != nil {
+		return "", nil, err
+	}
+	header, err := self.bufReader.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 3)
+	if len(fields) == 3 && fields[0] == "ERR" {
+		code, _ := strconv.Atoi(fields[1])
+		return "", nil, &ServerError{Code: code, Message: fields[2]}
+	}
+	if len(fields) != 3 || fields[0] != "OK" {
+		return "", nil, fmt.Errorf("bad frame header from server: %q", header)
+	}
+	count, err := strconv.Atoi(fields[2])
+	if err != nil || count < 0 {
+		return "", nil, fmt.Errorf("bad frame header from server: %q", header)
+	}
+
+	lines := make([]string, count)
+	for i := range lines {
+		line, err := self.bufReader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		lines[i] = strings.TrimRight(line, "\r\n")
+	}
+	return fields[1], lines, nil
+}
+
+// Ping checks the server is there.
+func (self *ServerConn) Ping() error {
+	_, _, err := self.Command("PING")
+	return err
+}
+
+// Info gets the server's INFO lines as a map, like "HEIGHT" -> "1234".
+func (self *ServerConn) Info() (map[string]string, error) {
+	_, lines, err := self.Command("INFO")
+	if err != nil {
+		return nil, err
+	}
+	info := make(map[string]string)
+	for _, line := range lines {
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) == 2 {
+			info[kv[0]] = kv[1]
+		}
+	}
+	return info, nil
+}
+
+// GetBlock gets a block by its hash.  It can be on a side branch.
+func (self *ServerConn) GetBlock(h Hash) (ChainBlock, error) {
+	return self.getOneBlock(fmt.Sprintf("GETBLOCK %s", h.ToString()))
+}
+
+// GetHeight gets the main chain block at a height.
+func (self *ServerConn) GetHeight(height uint64) (ChainBlock, error) {
+	return self.getOneBlock(fmt.Sprintf("GETHEIGHT %d", height))
+}
+
+// GetRange gets up to count main chain blocks starting at height from.
+func (self *ServerConn) GetRange(from, count uint64) ([]ChainBlock, error) {
+	_, lines, err := self.Command(fmt.Sprintf("GETRANGE %d %d", from, count))
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]ChainBlock, len(lines))
+	for i, line := range lines {
+		blocks[i], err = chainBlockFromLine(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// SyncChain downloads the main chain from height from to the tip, a few
+// hundred blocks at a time, and checks each block points at the one before.
+func (self *ServerConn) SyncChain(from uint64) ([]ChainBlock, error) {
+	var chain []ChainBlock
+	for {
+		blocks, err := self.GetRange(from+uint64(len(chain)), 500)
+		if err != nil {
+			return chain, err
+		}
+		if len(blocks) == 0 {
+			return chain, nil
+		}
+		for _, cb := range blocks {
+			if len(chain) > 0 && cb.Block.PrevHash != chain[len(chain)-1].Block.Hash() {
+				// the chain reorged between requests; the caller can
+				// try again from a bit further back
+				return chain, fmt.Errorf("block at height %d doesn't connect", cb.Height)
+			}
+			chain = append(chain, cb)
+		}
+	}
+}
+
+// getOneBlock sends a command that returns a single block.
+func (self *ServerConn) getOneBlock(cmd string) (ChainBlock, error) {
+	_, lines, err := self.Command(cmd)
+	if err != nil {
+		return ChainBlock{}, err
+	}
+	if len(lines) != 1 {
+		return ChainBlock{}, fmt.Errorf("got %d blocks from server, expect 1", len(lines))
+	}
+	return chainBlockFromLine(lines[0])
+}
+
+// chainBlockFromLine reads a "<height> <main|side> <block>" line.
+func chainBlockFromLine(line string) (ChainBlock, error) {
+	var cb ChainBlock
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return cb, fmt.Errorf("bad block line from server: %q", line)
+	}
+	height, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return cb, fmt.Errorf("bad block line from server: %q", line)
+	}
+	cb.Block, err = BlockFromString(fields[2])
+	if err != nil {
+		return cb, err
+	}
+	cb.Height, cb.Main = height, fields[1] == "main"
+	return cb, nil
+}
@@ -3,83 +3,32 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"runtime"
 	"strings"
 	"time"
 )
 
-// A hash is a sha256 hash, as in pset01
-type Hash [32]byte
+// minerName is who gets credit for mined blocks.  Names have to be in the
+// base64 charset (see the README), so this can't be an email address any
+// more; it used to be zhejyan@microsoft.com, and the server now rejects the
+// @ and the dots in new blocks.  Only blocks already on the old chain can
+// have them.
+const minerName = "zhejyan"
 
-// ToString gives you a hex string of the hash
-func (self Hash) ToString() string {
-	return fmt.Sprintf("%x", self)
-}
-
-// Blocks are what make the chain in this pset; different than just a 32 byte array
-// from last time.  Has a previous block hash, a name and a nonce.
-type Block struct {
-	PrevHash Hash
-	Name     string
-	Nonce    string
-}
-
-// ToString turns a block into an ascii string which can be sent over the
-// network or printed to the screen.
-func (self Block) ToString() string {
-	return fmt.Sprintf("%x %s %s", self.PrevHash, self.Name, self.Nonce)
-}
-
-// Hash returns the sha256 hash of the block.  Hopefully starts with zeros!
-func (self Block) Hash() Hash {
-	return sha256.Sum256([]byte(self.ToString()))
-}
-
-// BlockFromString takes in a string and converts it to a block, if possible
-func BlockFromString(s string) (Block, error) {
-	var bl Block
-
-	// check string length
-	if len(s) < 66 || len(s) > 100 {
-		return bl, fmt.Errorf("Invalid string length %d, expect 66 to 100", len(s))
-	}
-	// split into 3 substrings via spaces
-	subStrings := strings.Split(s, " ")
+func main() {
 
-	if len(subStrings) != 3 {
-		return bl, fmt.Errorf("got %d elements, expect 3", len(subStrings))
-	}
+	fmt.Printf("NameChain Miner v0.1\n")
 
-	hashbytes, err := hex.DecodeString(subStrings[0])
+	// no point mining for hours to have the server reject the name
+	err := ValidateName(minerName)
 	if err != nil {
-		return bl, err
-	}
-	if len(hashbytes) != 32 {
-		return bl, fmt.Errorf("got %d byte hash, expect 32", len(hashbytes))
+		fmt.Println(err)
+		return
 	}
 
-	copy(bl.PrevHash[:], hashbytes)
-
-	bl.Name = subStrings[1]
-
-	// remove trailing newline if there; the blocks don't include newlines, but
-	// when transmitted over TCP there's a newline to signal end of block
-	bl.Nonce = strings.TrimSpace(subStrings[2])
-
-	// TODO add more checks on name/nonce ...?
-
-	return bl, nil
-}
-
-func main() {
-
-	fmt.Printf("NameChain Miner v0.1\n")
-
 	ticker := time.NewTicker(5 * time.Minute)
-	remine := make(chan *Block)
+	remine := make(chan *TipInfo)
 	defer close(remine)
 	var tip Block
 	go func() {
@@ -88,18 +37,18 @@ func main() {
 			select {
 			case <-ticker.C:
 				runtime.GC()
-				_latestTip, err := GetTipFromServer()
+				info, err := GetTipInfoFromServer()
 				if err != nil {
 					fmt.Println(err)
 					continue PollTip
 				}
 
-				if bytes.Equal(tip.PrevHash[:], _latestTip.PrevHash[:]) && tip.Nonce != "" {
+				if bytes.Equal(tip.PrevHash[:], info.Tip.PrevHash[:]) && tip.Nonce != "" {
 					fmt.Printf("tip not changed, prev hash: [%s]\n", tip.PrevHash.ToString())
 					continue PollTip
 				} else {
-					remine <- &_latestTip
-					tip = _latestTip
+					remine <- &info
+					tip = info.Tip
 				}
 			}
 		}
@@ -113,9 +62,18 @@ MineLoop:
 	for {
 		fmt.Println("waiting for signals...")
 		select {
-		case newTip := <-remine:
+		case job := <-remine:
 			ctx, cancel := context.WithCancel(context.Background())
-			bl := &Block{PrevHash: newTip.Hash(), Name: "zhejyan@microsoft.com"}
+			bl := &Block{
+				Version:   BlockVersionTime,
+				Timestamp: time.Now().Unix(),
+				PrevHash:  job.Tip.Hash(),
+				Name:      minerName,
+			}
+			// version 2 if we know what height the block goes at
+			if job.HaveHeight {
+				bl.Version, bl.Height = BlockVersionHeight, job.Height+1
+			}
 			if lastMiningCtx == nil {
 				fmt.Printf("start remining ..\n")
 			} else {
@@ -124,9 +82,16 @@ MineLoop:
 			}
 			lastMiningCtx = ctx
 			cancelLastMining = cancel
-			bl.Mine(ctx, uint8(33), getBlock)
+			fmt.Printf("mining at target %s (%.2f bits)\n",
+				TargetToString(job.Target), TargetBits(job.Target))
+			bl.Mine(ctx, job.Target, getBlock)
 		case blk := <-getBlock:
 			fmt.Printf("SUCCESSFULLY mined a block! Sending to server.. PrevHash : [%s], name: [%s], nonce: [%s]\n", blk.PrevHash.ToString(), blk.Name, blk.Nonce)
+			err := blk.ValidateSubmission()
+			if err != nil {
+				fmt.Printf("mined block is invalid, not sending: %s\n", err)
+				continue MineLoop
+			}
 			msg, err := SendBlockToServer(*blk)
 			if strings.Contains(msg, "Block accepted") {
 				fmt.Printf("SUCCESSFULLY submit a block! PrevHash : [%s], name: [%s], nonce: [%s]\n", blk.PrevHash.ToString(), blk.Name, blk.Nonce)
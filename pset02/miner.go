@@ -1,21 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math/big"
 	"runtime"
 	"strconv"
 	"sync"
 )
 
 // This file is for the mining code.
-// Note that "targetBits" for this assignment, at least initially, is 33.
-// This could change during the assignment duration!  I will post if it does.
+// The target started out as 33 zero bits, but the server retargets now, and
+// sends the current target along with the tip.
 
-// Mine mines a block by varying the nonce until the hash has targetBits 0s in
-// the beginning.  Could take forever if targetBits is too high.
+// Mine mines a block by varying the nonce until the hash is at or below the
+// target.  Could take forever if the target is too low.
 // Modifies a block in place by using a pointer receiver.
-func (self *Block) Mine(ctx context.Context, targetBits uint8, GetBlock chan *Block) {
+func (self *Block) Mine(ctx context.Context, target *big.Int, GetBlock chan *Block) {
 	// your mining code here
 	// also feel free to get rid of this method entirely if you want to
 	// organize things a different way; this is just a suggestion
@@ -24,6 +26,8 @@ func (self *Block) Mine(ctx context.Context, targetBits uint8, GetBlock chan *Bl
 	var once sync.Once
 	wg.Add(runtime.NumCPU())
 	ctxCancel, cancel := context.WithCancel(ctx)
+	// compare hashes against the target's bytes; no big.Int per hash
+	targetBytes := target.FillBytes(make([]byte, 32))
 	defer func() {
 		go func() {
 			fmt.Println("waiting for the current block mining exit...")
@@ -40,8 +44,11 @@ func (self *Block) Mine(ctx context.Context, targetBits uint8, GetBlock chan *Bl
 
 	for mineWorkerId := 0; mineWorkerId < runtime.NumCPU(); mineWorkerId++ {
 		_bl := &Block{
-			PrevHash: self.PrevHash,
-			Name:     self.Name}
+			Version:   self.Version,
+			Height:    self.Height,
+			Timestamp: self.Timestamp,
+			PrevHash:  self.PrevHash,
+			Name:      self.Name}
 		go func(_ctx context.Context, taskId int, bl *Block) {
 		TryNextNonce:
 			for nonce := uint64(taskId); ; nonce += uint64(runtime.NumCPU()) {
@@ -55,14 +62,10 @@ func (self *Block) Mine(ctx context.Context, targetBits uint8, GetBlock chan *Bl
 				bl.Nonce = strconv.FormatUint(nonce, 10)
 				h := bl.Hash()
 				//fmt.Printf("TaskId: [%d], nonce: [%d], hash : [%s]\n", taskId, nonce, h.ToString())
-				for i := uint8(0); i < targetBits; i++ {
-					// for every bit from the MSB down, check if it's a 1.
-					// If it is, stop and fail.
-					// Could definitely speed this up by checking bytes at a time.
-					// Left as excercise for the reader...?
-					if (h[i/8]>>(7-(i%8)))&0x01 == 1 {
-						continue TryNextNonce
-					}
+				// hash and target are both big endian, so bytes.Compare
+				// compares them as numbers
+				if bytes.Compare(h[:], targetBytes) > 0 {
+					continue TryNextNonce
 				}
 				fmt.Printf("TaskId: [%d], nonce: [%d], hash : [%s], current block mining success..\n", taskId, nonce, h.ToString())
 				once.Do(func() {
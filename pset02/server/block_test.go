@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// TestBlockFromString round trips both block formats, and checks that the
+// genesis block hashes the way the existing chain expects.
+func TestBlockFromString(t *testing.T) {
+	genesis, err := BlockFromString(genesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Version != BlockVersionLegacy || genesis.ToString() != genesisBlock {
+		t.Fatalf("genesis: got %+v", genesis)
+	}
+	if !strings.HasPrefix(genesis.Hash().ToString(), "00000000722a3b3c") {
+		t.Fatalf("genesis hash %s", genesis.Hash().ToString())
+	}
+	if !CheckWork(genesis, TargetFromBits(33)) || CheckWork(genesis, TargetFromBits(34)) {
+		t.Fatalf("genesis should have exactly 33 bits of work")
+	}
+
+	timed := Block{
+		Version:   BlockVersionTime,
+		Timestamp: 1519862399,
+		PrevHash:  genesis.Hash(),
+		Name:      "miner2049",
+		Nonce:     "TWFuI3GlzIGR",
+	}
+	tall := timed
+	tall.Version, tall.Height = BlockVersionHeight, 12345
+	for _, bl := range []Block{genesis, timed, tall} {
+		got, err := BlockFromString(bl.ToString() + "\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != bl {
+			t.Fatalf("round trip: got %+v, expect %+v", got, bl)
+		}
+	}
+
+	hash := genesis.Hash().ToString()
+	for _, s := range []string{
+		"",
+		hash + " name",
+		hash + " name nonce extra",
+		"3 1519862399 " + hash + " name nonce",
+		"2 1519862399 " + hash + " name nonce",
+		"1 5 1519862399 " + hash + " name nonce",
+		"2 0 1519862399 " + hash + " name nonce",
+		"1 -5 " + hash + " name nonce",
+		"1 x " + hash + " name nonce",
+		"1 1519862399 " + hash + " name " + strings.Repeat("n", 60),
+	} {
+		if _, err := BlockFromString(s); err == nil {
+			t.Fatalf("BlockFromString(%q) worked, expect an error", s)
+		}
+	}
+}
+
+// TestTargets checks the target helpers.
+func TestTargets(t *testing.T) {
+	target := TargetFromBits(33)
+	if s := TargetToString(target); s != "000000007fffffffffffffffffffffffffffffffffffffffffffffffffffffff" {
+		t.Fatalf("33 bit target %s", s)
+	}
+	if bits := TargetBits(target); bits != 33 {
+		t.Fatalf("TargetBits gave %f, expect 33", bits)
+	}
+	// half way between 33 and 34 bits
+	mid := new(big.Int).Rsh(new(big.Int).Mul(target, big.NewInt(3)), 2)
+	if bits := TargetBits(mid); bits < 33.4 || bits > 33.5 {
+		t.Fatalf("TargetBits gave %f, expect about 33.42", bits)
+	}
+
+	got, err := TargetFromString(TargetToString(mid))
+	if err != nil || got.Cmp(mid) != 0 {
+		t.Fatalf("target round trip: %v", err)
+	}
+	for _, s := range []string{"", "00", strings.Repeat("0", 64), strings.Repeat("g", 64)} {
+		if _, err := TargetFromString(s); err == nil {
+			t.Fatalf("TargetFromString(%q) worked, expect an error", s)
+		}
+	}
+}
+
+// TestBlockRules checks each block rule is enforced, and that the error says
+// which rule it was.
+func TestBlockRules(t *testing.T) {
+	hash := "00000000722a3b3cabaac078bd4e15ce361312895cfef0494c9ffc75bedb82db"
+	good := []string{
+		hash + " miner2049 TWFuI3GlzIGR",
+		hash + " a+/Z 0",
+		"1 1519862399 " + hash + " miner2049 TWFuI3GlzIGR",
+		"2 7 1519862399 " + hash + " miner2049 TWFuI3GlzIGR",
+		hash + " " + strings.Repeat("n", 33) + " 0",
+		// legacy blocks on the old chain predate the charset
+		hash + " zhejyan@microsoft.com 305346813",
+	}
+	for _, s := range good {
+		if _, err := BlockFromString(s); err != nil {
+			t.Fatalf("BlockFromString(%q): %v", s, err)
+		}
+	}
+
+	bad := []struct{ s, rule string }{
+		{hash + " " + strings.Repeat("n", 34) + " 0", "length"},
+		{"1 1519862399 " + hash + " " + strings.Repeat("n", 50) + " 0", "length"},
+		{hash + " miner2049", "fields"},
+		{hash + "  miner2049 0", "fields"},
+		{"1 1519862399 " + hash + " miner2049", "fields"},
+		{"2 1519862399 " + hash + " miner2049 0", "fields"},
+		{"3 1519862399 " + hash + " miner2049 0", "version"},
+		{"01 1519862399 " + hash + " miner2049 0", "number"},
+		{"1 +1519862399 " + hash + " miner2049 0", "number"},
+		{"2 0 1519862399 " + hash + " miner2049 0", "height"},
+		{"1 0 " + hash + " miner2049 0", "timestamp"},
+		{strings.ToUpper(hash) + " miner2049 0", "prevhash"},
+		{hash[2:] + " miner2049 0", "prevhash"},
+		{"1 1519862399 " + hash + " zhejyan@microsoft.com 0", "name"},
+		{hash + " miner\x002049 0", "name"},
+		{hash + " na\x01me 12", "name"},
+		{hash + " \t x", "name"},
+		{hash + "  0", "name"},
+		{hash + " miner2049 ", "nonce"},
+		{hash + " miner2049 n\x7fnce", "nonce"},
+		{"1 1519862399 " + hash + " miner\x002049 0", "name"},
+		{"1 1519862399 " + hash + "  0", "name"},
+		{"2 7 1519862399 " + hash + " miner2049 ", "nonce"},
+		{"2 7 1519862399 " + hash + " miner2049 n-nce", "nonce"},
+	}
+	for _, b := range bad {
+		_, err := BlockFromString(b.s)
+		var berr *BlockError
+		if !errors.As(err, &berr) || berr.Rule != b.rule {
+			t.Fatalf("BlockFromString(%q): got %v, expect rule %q", b.s, err, b.rule)
+		}
+	}
+
+	if err := (Block{Version: BlockVersionTime, Timestamp: 1519862399, Name: "miner2049"}).Validate(); err == nil {
+		t.Fatalf("block with no nonce validated")
+	}
+	if err := (Block{Name: "miner 2049", Nonce: "0"}).Validate(); err == nil {
+		t.Fatalf("legacy block with a space in the name validated")
+	}
+
+	// legacy blocks on the old chain can be outside the charset, but
+	// submitted ones can't
+	for _, b := range []struct{ s, rule string }{
+		{hash + " zhejyan@microsoft.com 305346813", "name"},
+		{hash + " miner2049 n-nce", "nonce"},
+	} {
+		bl, err := BlockFromString(b.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var berr *BlockError
+		err = bl.ValidateSubmission()
+		if !errors.As(err, &berr) || berr.Rule != b.rule {
+			t.Fatalf("ValidateSubmission(%q): got %v, expect rule %q", b.s, err, b.rule)
+		}
+	}
+	for _, s := range good[:len(good)-1] {
+		bl, _ := BlockFromString(s)
+		if err := bl.ValidateSubmission(); err != nil {
+			t.Fatalf("ValidateSubmission(%q): %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// The block index keeps every valid block the server has seen, not just the
+// ones on the main chain.
+
+// Before, the server only kept the tip, and a block was only accepted if it
+// pointed right at it.  If two miners found blocks on the same parent, the
+// second one was thrown away for good, even if someone then mined on top of
+// it.  Now each block goes in a map keyed by its hash, with a pointer to its
+// parent, its height, and the total work of the chain ending at it.  A block
+// can build on any block in the index.  The main chain is whichever chain
+// has the most total work; if a side branch gets more work than the main
+// chain, the tip switches over to it (a reorg), and the blocks on the old
+// branch stop counting.
+
+// On a tie the first chain seen stays the main chain, same as bitcoin.
+
+// Difficulty retargets every RetargetInterval blocks.  The time the last
+// RetargetInterval blocks took is compared to how long they should have
+// taken at one block every TargetSpacing seconds, and the target is scaled
+// by actual/expected: blocks coming in twice as fast halves the target,
+// which doubles the work.  The change is limited to a factor of 4 either way
+// per retarget, and the target can't get easier than powLimit.
+
+// Only version 1 and up blocks have timestamps, so the target only moves
+// once a whole window is versioned blocks.  Until then it stays at initialTarget,
+// which is the old 33 bit rule, so the existing legacy chain is still valid.
+// A block can't have a lower version than its parent, so once timestamps
+// start they don't stop.
+
+// Since retargeting goes by timestamps, miners can't be allowed to put just
+// anything in them.  Like bitcoin, a block's timestamp has to be after the
+// median of the last 11 timestamps before it (median time past), so it can't
+// go back in time much, and no more than 2 hours ahead of the server's clock.
+// A block too far in the future isn't remembered as bad; it can be sent
+// again later.  Version 2 blocks also say their height, which has to be
+// right.
+
+const (
+	RetargetInterval  = 32
+	TargetSpacing     = 5 * 60 // seconds
+	maxRetargetFactor = 4
+
+	medianTimeBlocks   = 11
+	MaxFutureBlockTime = 2 * 60 * 60 // seconds
+)
+
+var (
+	// initialTarget is the target before any retargeting.
+	initialTarget = TargetFromBits(33)
+	// powLimit is the easiest the target can ever get.
+	powLimit = TargetFromBits(24)
+)
+
+// A blockNode is a block in the index.
+type blockNode struct {
+	block  Block
+	hash   Hash
+	parent *blockNode
+	height uint64   // genesis is 0, the first block mined on it is 1
+	target *big.Int // target this block had to meet
+	work   *big.Int // total work of the chain ending at this block
+}
+
+// BlockIndex is the tree of all blocks, and which one is the tip.  Not safe
+// for concurrent use; the server holds bc.mtx around it.
+type BlockIndex struct {
+	nodes   map[Hash]*blockNode
+	genesis *blockNode
+	tip     *blockNode
+	main    []*blockNode     // main chain by height; main[0] is genesis
+	now     func() time.Time // the clock for checking timestamps
+}
+
+// AddResult says what happened when a block was added.
+type AddResult struct {
+	Height    uint64 // height of the new block
+	MainChain bool   // the new block is on the main chain now
+	Reorg     uint64 // if the tip switched branches, how many blocks were disconnected
+	OldTip    Hash   // tip before the block was added
+}
+
+// NewBlockIndex makes an index with just the genesis block in it.  Genesis
+// counts as no work; everything is measured from it.
+func NewBlockIndex(genesis Block) *BlockIndex {
+	g := &blockNode{
+		block:  genesis,
+		hash:   genesis.Hash(),
+		target: initialTarget,
+		work:   new(big.Int),
+	}
+	return &BlockIndex{
+		nodes:   map[Hash]*blockNode{g.hash: g},
+		genesis: g,
+		tip:     g,
+		main:    []*blockNode{g},
+		now:     time.Now,
+	}
+}
+
+// blockWork is the expected number of hashes to find a block at or below
+// the target: 2^256 / (target+1).
+func blockWork(target *big.Int) *big.Int {
+	w := new(big.Int).Lsh(big.NewInt(1), 256)
+	return w.Div(w, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// Tip returns the block at the tip of the main chain.
+func (self *BlockIndex) Tip() Block {
+	return self.tip.block
+}
+
+// Height returns the height of the main chain.
+func (self *BlockIndex) Height() uint64 {
+	return self.tip.height
+}
+
+// NextTarget returns the target for the next block on the tip.
+func (self *BlockIndex) NextTarget() *big.Int {
+	return nextTarget(self.tip)
+}
+
+// MainChain returns the blocks on the main chain in order, starting from the
+// one after genesis.
+func (self *BlockIndex) MainChain() []Block {
+	return self.MainChainRange(1, self.tip.height)
+}
+
+// MainChainRange returns up to count main chain blocks starting at height
+// from.  Fewer if the chain isn't that long.
+func (self *BlockIndex) MainChainRange(from, count uint64) []Block {
+	var blocks []Block
+	for h := from; h < uint64(len(self.main)) && h-from < count; h++ {
+		blocks = append(blocks, self.main[h].block)
+	}
+	return blocks
+}
+
+// BlockAtHeight returns the main chain block at a height; height 0 is
+// genesis.
+func (self *BlockIndex) BlockAtHeight(height uint64) (Block, bool) {
+	if height >= uint64(len(self.main)) {
+		return Block{}, false
+	}
+	return self.main[height].block, true
+}
+
+// BlockByHash finds any block in the index, main chain or not, and returns
+// its height and whether it's on the main chain.
+func (self *BlockIndex) BlockByHash(h Hash) (bl Block, height uint64, main bool, ok bool) {
+	n := self.nodes[h]
+	if n == nil {
+		return Block{}, 0, false, false
+	}
+	// a side branch can be longer than the main chain if its blocks had
+	// easier targets
+	main = n.height < uint64(len(self.main)) && self.main[n.height] == n
+	return n.block, n.height, main, true
+}
+
+// TotalWork is the total work of the main chain.
+func (self *BlockIndex) TotalWork() *big.Int {
+	return new(big.Int).Set(self.tip.work)
+}
+
+// Size is how many blocks are in the index, including genesis and side
+// branches.
+func (self *BlockIndex) Size() int {
+	return len(self.nodes)
+}
+
+// CheckBlock checks that the block follows the block rules (see block.go),
+// isn't one we already have, builds on a
+// block we do have, has a version at least as high as its parent's, has the
+// right height and a sensible timestamp if it has them, and has enough work
+// for its place in the chain.  Returns the parent.
+func (self *BlockIndex) CheckBlock(bl Block) (*blockNode, error) {
+	err := bl.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if self.nodes[bl.Hash()] != nil {
+		return nil, fmt.Errorf("already have block %x", bl.Hash())
+	}
+	parent := self.nodes[bl.PrevHash]
+	if parent == nil {
+		return nil, fmt.Errorf("unknown parent %x", bl.PrevHash)
+	}
+	if bl.Version < parent.block.Version {
+		return nil, fmt.Errorf("block version %d is lower than its parent's, %d",
+			bl.Version, parent.block.Version)
+	}
+	if bl.Version >= BlockVersionHeight && bl.Height != parent.height+1 {
+		return nil, fmt.Errorf("block says height %d, but it would be at height %d",
+			bl.Height, parent.height+1)
+	}
+	if bl.Version >= BlockVersionTime {
+		mtp := medianTimePast(parent)
+		if bl.Timestamp <= mtp {
+			return nil, fmt.Errorf("timestamp %d is not after median time past %d",
+				bl.Timestamp, mtp)
+		}
+		limit := self.now().Unix() + MaxFutureBlockTime
+		if bl.Timestamp > limit {
+			return nil, fmt.Errorf("timestamp %d is more than %d seconds in the future",
+				bl.Timestamp, MaxFutureBlockTime)
+		}
+	}
+	target := nextTarget(parent)
+	if !CheckWork(bl, target) {
+		return nil, fmt.Errorf("not enough work, need hash at or below %s (%.2f bits)",
+			TargetToString(target), TargetBits(target))
+	}
+	return parent, nil
+}
+
+// Add checks the block and puts it in the index, switching the tip if the
+// block's chain now has the most work.
+func (self *BlockIndex) Add(bl Block) (AddResult, error) {
+	res := AddResult{OldTip: self.tip.hash}
+	parent, err := self.CheckBlock(bl)
+	if err != nil {
+		return res, err
+	}
+
+	n := &blockNode{
+		block:  bl,
+		hash:   bl.Hash(),
+		parent: parent,
+		height: parent.height + 1,
+		target: nextTarget(parent),
+	}
+	n.work = new(big.Int).Add(parent.work, blockWork(n.target))
+	self.nodes[n.hash] = n
+	res.Height = n.height
+
+	if n.work.Cmp(self.tip.work) <= 0 {
+		return res, nil
+	}
+	res.MainChain = true
+	fork := parent
+	if parent != self.tip {
+		fork = findFork(self.tip, n)
+		res.Reorg = self.tip.height - fork.height
+	}
+	self.tip = n
+
+	// swap the new branch into the main chain, after the fork
+	self.main = self.main[:fork.height+1]
+	for i := n.height; i > fork.height; i-- {
+		self.main = append(self.main, nil)
+	}
+	for m := n; m != fork; m = m.parent {
+		self.main[m.height] = m
+	}
+	return res, nil
+}
+
+// findFork returns the last block two branches have in common.
+func findFork(a, b *blockNode) *blockNode {
+	for a.height > b.height {
+		a = a.parent
+	}
+	for b.height > a.height {
+		b = b.parent
+	}
+	for a != b {
+		a, b = a.parent, b.parent
+	}
+	return a
+}
+
+// ancestor walks back to the block at the given height.
+func (self *blockNode) ancestor(height uint64) *blockNode {
+	n := self
+	for n.height > height {
+		n = n.parent
+	}
+	return n
+}
+
+// nextTarget works out the target for a block built on parent.
+func nextTarget(parent *blockNode) *big.Int {
+	height := parent.height + 1
+	if height%RetargetInterval != 0 || parent.height < RetargetInterval {
+		return parent.target
+	}
+	first := parent.ancestor(parent.height - RetargetInterval)
+	if first.block.Version < BlockVersionTime {
+		// no timestamps to go on yet
+		return parent.target
+	}
+
+	expected := int64(RetargetInterval * TargetSpacing)
+	actual := parent.block.Timestamp - first.block.Timestamp
+	actual = max(actual, expected/maxRetargetFactor)
+	actual = min(actual, expected*maxRetargetFactor)
+
+	target := new(big.Int).Mul(parent.target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+	return target
+}
+
+// medianTimePast is the median timestamp of the last medianTimeBlocks blocks
+// ending at n, counting only blocks that have timestamps.  0 if none do.
+func medianTimePast(n *blockNode) int64 {
+	var times []int64
+	for ; n != nil && len(times) < medianTimeBlocks; n = n.parent {
+		if n.block.Version < BlockVersionTime {
+			break
+		}
+		times = append(times, n.block.Timestamp)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWorkBits is low enough that mining a test block takes a few hundred
+// hashes.
+const testWorkBits = 8
+
+// useTestWork lowers initialTarget and powLimit for one test.
+func useTestWork(t *testing.T) {
+	oldInitial, oldLimit := initialTarget, powLimit
+	initialTarget, powLimit = TargetFromBits(testWorkBits), TargetFromBits(testWorkBits-1)
+	t.Cleanup(func() { initialTarget, powLimit = oldInitial, oldLimit })
+}
+
+// mineTestBlock finds a nonce for a legacy block on prev.
+func mineTestBlock(t *testing.T, prev Hash, name string) Block {
+	return mineTestTarget(Block{PrevHash: prev, Name: name}, initialTarget)
+}
+
+// mineTestTarget finds a nonce that gets bl under the target.
+func mineTestTarget(bl Block, target *big.Int) Block {
+	for nonce := uint64(0); ; nonce++ {
+		bl.Nonce = strconv.FormatUint(nonce, 10)
+		if CheckWork(bl, target) {
+			return bl
+		}
+	}
+}
+
+// mineTestBranch mines n blocks in a row on prev.
+func mineTestBranch(t *testing.T, prev Hash, name string, n int) []Block {
+	var blocks []Block
+	for i := 0; i < n; i++ {
+		bl := mineTestBlock(t, prev, name)
+		blocks = append(blocks, bl)
+		prev = bl.Hash()
+	}
+	return blocks
+}
+
+// TestBlockIndexReorg builds a main chain and a side branch off genesis, and
+// checks the tip only moves once the side branch has strictly more work.
+func TestBlockIndexReorg(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	index := NewBlockIndex(genesis)
+
+	a := mineTestBranch(t, genesis.Hash(), "alice", 2)
+	b := mineTestBranch(t, genesis.Hash(), "bob", 3)
+
+	for i, bl := range a {
+		res, err := index.Add(bl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.MainChain || res.Reorg != 0 || res.Height != uint64(i+1) {
+			t.Fatalf("alice block %d: got %+v", i, res)
+		}
+	}
+
+	// two bob blocks is a tie, so alice stays the main chain
+	for i, bl := range b[:2] {
+		res, err := index.Add(bl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.MainChain {
+			t.Fatalf("bob block %d took over the main chain: %+v", i, res)
+		}
+	}
+	if index.Tip() != a[1] {
+		t.Fatalf("tip moved on a tie")
+	}
+
+	res, err := index.Add(b[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.MainChain || res.Reorg != 2 || res.Height != 3 || res.OldTip != a[1].Hash() {
+		t.Fatalf("bob block 2: got %+v, expect a reorg of depth 2", res)
+	}
+	chain := index.MainChain()
+	if len(chain) != 3 || chain[0] != b[0] || chain[2] != b[2] {
+		t.Fatalf("main chain after reorg: %v", chain)
+	}
+
+	// alice extending her branch doesn't get the tip back until it's heavier
+	a = append(a, mineTestBranch(t, a[1].Hash(), "alice", 2)...)
+	if res, _ = index.Add(a[2]); res.MainChain {
+		t.Fatalf("tie took the tip back: %+v", res)
+	}
+	if res, _ = index.Add(a[3]); !res.MainChain || res.Reorg != 3 {
+		t.Fatalf("alice block 3: got %+v, expect a reorg of depth 3", res)
+	}
+}
+
+// TestExistingChain checks every block in chain.txt still parses and goes on
+// genesis at the original 33 bit target, so the old chain reloads.
+func TestExistingChain(t *testing.T) {
+	data, err := os.ReadFile(chainFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, _ := BlockFromString(genesisBlock)
+	index := NewBlockIndex(genesis)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		bl, err := BlockFromString(line)
+		if err != nil {
+			t.Fatalf("BlockFromString(%q): %v", line, err)
+		}
+		if !CheckWork(bl, TargetFromBits(33)) {
+			t.Fatalf("block %q doesn't have 33 bits of work", line)
+		}
+		res, err := index.Add(bl)
+		if err != nil {
+			t.Fatalf("adding %q: %v", line, err)
+		}
+		if !res.MainChain {
+			t.Fatalf("block %q didn't extend the main chain: %+v", line, res)
+		}
+	}
+}
+
+// TestBlockIndexReject checks that duplicates, orphans and blocks without
+// enough work are turned away.
+func TestBlockIndexReject(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	index := NewBlockIndex(genesis)
+
+	bl := mineTestBlock(t, genesis.Hash(), "alice")
+	if _, err := index.Add(bl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.Add(bl); err == nil {
+		t.Fatalf("added the same block twice")
+	}
+	if _, err := index.Add(mineTestBlock(t, Hash{1}, "alice")); err == nil {
+		t.Fatalf("added a block with an unknown parent")
+	}
+
+	weak := Block{PrevHash: genesis.Hash(), Name: "alice", Nonce: "0"}
+	for n := 1; CheckWork(weak, initialTarget); n++ {
+		weak.Nonce = strconv.Itoa(n)
+	}
+	if _, err := index.Add(weak); err == nil {
+		t.Fatalf("added a block without enough work")
+	}
+}
+
+// mineTestTimed mines n version 1 blocks on the index's tip, spacing the
+// timestamps by the given number of seconds.
+func mineTestTimed(t *testing.T, index *BlockIndex, n int, start, spacing int64) {
+	for i := 0; i < n; i++ {
+		bl := Block{
+			Version:   BlockVersionTime,
+			Timestamp: start + int64(i)*spacing,
+			PrevHash:  index.Tip().Hash(),
+			Name:      "alice",
+		}
+		_, err := index.Add(mineTestTarget(bl, index.NextTarget()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRetarget checks the target moves by actual/expected time at the right
+// heights, and is clamped to a factor of 4 and to powLimit.
+func TestRetarget(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	start := int64(1500000000)
+
+	// blocks twice as fast as they should be: target halves at height 64
+	index := NewBlockIndex(genesis)
+	mineTestTimed(t, index, 2*RetargetInterval-1, start, TargetSpacing/2)
+	if index.NextTarget().Cmp(initialTarget) == 0 {
+		t.Fatalf("no retarget at height %d", index.Height()+1)
+	}
+	half := new(big.Int).Rsh(initialTarget, 1)
+	if index.NextTarget().Cmp(half) != 0 {
+		t.Fatalf("target %s, expect %s", TargetToString(index.NextTarget()), TargetToString(half))
+	}
+	// a block that only meets the old target is rejected
+	bl := Block{
+		Version:   BlockVersionTime,
+		Timestamp: index.Tip().Timestamp + 1,
+		PrevHash:  index.Tip().Hash(),
+		Name:      "bob",
+	}
+	for n := 0; !CheckWork(bl, initialTarget) || CheckWork(bl, half); n++ {
+		bl.Nonce = strconv.Itoa(n)
+	}
+	if _, err := index.Add(bl); err == nil {
+		t.Fatalf("block with only the old target's work accepted")
+	}
+	// and in between retargets it doesn't change
+	mineTestTimed(t, index, 1, index.Tip().Timestamp+1, 0)
+	if index.NextTarget().Cmp(half) != 0 {
+		t.Fatalf("target changed between retargets")
+	}
+
+	// a second apart: clamped to a quarter
+	index = NewBlockIndex(genesis)
+	mineTestTimed(t, index, 2*RetargetInterval-1, start, 1)
+	quarter := new(big.Int).Rsh(initialTarget, 2)
+	if index.NextTarget().Cmp(quarter) != 0 {
+		t.Fatalf("target %s, expect %s", TargetToString(index.NextTarget()), TargetToString(quarter))
+	}
+
+	// very slow blocks: 4 times easier, but powLimit is only twice as easy
+	index = NewBlockIndex(genesis)
+	mineTestTimed(t, index, 2*RetargetInterval-1, start, 100*TargetSpacing)
+	if index.NextTarget().Cmp(powLimit) != 0 {
+		t.Fatalf("target %s, expect powLimit", TargetToString(index.NextTarget()))
+	}
+
+	// legacy blocks can't follow versioned ones
+	legacy := mineTestBlock(t, index.Tip().Hash(), "alice")
+	if _, err := index.Add(legacy); err == nil {
+		t.Fatalf("legacy block accepted after a version 1 block")
+	}
+}
+
+// TestBlockTimestamps checks median time past, the future limit, and the
+// height in version 2 blocks.
+func TestBlockTimestamps(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	index := NewBlockIndex(genesis)
+	now := int64(1519862399)
+	index.now = func() time.Time { return time.Unix(now, 0) }
+
+	// a legacy block first; no timestamps to compare against yet
+	if _, err := index.Add(mineTestBlock(t, genesis.Hash(), "alice")); err != nil {
+		t.Fatal(err)
+	}
+	// 11 blocks, 10 minutes apart, ending an hour ago
+	start := now - 3600 - 10*600
+	mineTestTimed(t, index, medianTimeBlocks, start, 600)
+	mtp := start + 5*600
+
+	next := func(version uint32, height uint64, timestamp int64) error {
+		bl := Block{
+			Version:   version,
+			Height:    height,
+			Timestamp: timestamp,
+			PrevHash:  index.Tip().Hash(),
+			Name:      "bob",
+		}
+		_, err := index.Add(mineTestTarget(bl, index.NextTarget()))
+		return err
+	}
+	h := index.Height() + 1
+
+	if err := next(BlockVersionTime, 0, mtp); err == nil {
+		t.Fatalf("block at median time past accepted")
+	}
+	if err := next(BlockVersionTime, 0, now+MaxFutureBlockTime+1); err == nil {
+		t.Fatalf("block too far in the future accepted")
+	}
+	if err := next(BlockVersionHeight, h+1, now); err == nil {
+		t.Fatalf("version 2 block with the wrong height accepted")
+	}
+	// earlier than its parent is fine, as long as it's after median time past
+	if err := next(BlockVersionHeight, h, mtp+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(BlockVersionHeight, h+1, now+MaxFutureBlockTime); err != nil {
+		t.Fatal(err)
+	}
+	// and no going back to version 1 after version 2
+	if err := next(BlockVersionTime, 0, now); err == nil {
+		t.Fatalf("version 1 block accepted after a version 2 block")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The framed protocol, for clients that want more than the tip.
+
+// The original protocol is one line each way and then the server hangs up:
+// TRQ gets the tip, and a block line gets "Block accepted" or an error.
+// Those still work exactly the same.  The commands here are for clients that
+// want to look around the chain, or sync all of it.  After one of them the
+// connection stays open, and the client can send as many as it likes, one
+// per line.
+
+// Every response is a frame: a header line saying what type of response it
+// is and how many lines follow, then those lines.
+//   OK <type> <count>
+//   <count lines>
+// If the command failed, the frame is just one line with an error code:
+//   ERR <code> <message>
+// The codes are borrowed from HTTP: 400 for a bad command or arguments, 404
+// for a block that isn't there, 413 for asking for too many blocks at once.
+
+// Commands:
+//   PING                     OK PONG 0
+//   INFO                     OK INFO 5, then HEIGHT, TIP, TARGET, WORK and
+//                            BLOCKS lines, each "<key> <value>"
+//   GETBLOCK <hash>          OK BLOCK 1, block with that hash
+//   GETHEIGHT <n>            OK BLOCK 1, main chain block at height n
+//   GETRANGE <from> <count>  OK BLOCKS k, up to count main chain blocks from
+//                            height from; fewer (maybe 0) at the end of
+//                            the chain
+//   QUIT                     OK BYE 0, then the server hangs up
+// Each block line is
+//   <height> <main|side> <block>
+// GETBLOCK can find blocks on side branches; the others only look at the
+// main chain.  Blocks here are all header (there are no transactions), so
+// GETRANGE is also how to get headers.
+
+// Error codes.
+const (
+	ErrCodeBadRequest = 400
+	ErrCodeNotFound   = 404
+	ErrCodeTooLarge   = 413
+)
+
+const (
+	// maxRangeCount is the most blocks GETRANGE sends at once.
+	maxRangeCount = 500
+	// protocolIdleTimeout is how long a framed connection can sit idle.
+	protocolIdleTimeout = 5 * time.Minute
+)
+
+// framedCommands are the commands that start a framed session, and how many
+// arguments each takes.
+var framedCommands = map[string]int{
+	"PING": 0, "INFO": 0, "GETBLOCK": 1, "GETHEIGHT": 1, "GETRANGE": 2, "QUIT": 0,
+}
+
+// isFramedCommand says whether the line is one of the framed commands,
+// rather than TRQ or a block.
+func isFramedCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	_, ok := framedCommands[fields[0]]
+	return ok
+}
+
+// okFrame makes a frame with the given type and lines.
+func okFrame(typ string, lines ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "OK %s %d\n", typ, len(lines))
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// errFrame makes an error frame.
+func errFrame(code int, format string, args ...interface{}) string {
+	return fmt.Sprintf("ERR %d %s\n", code, fmt.Sprintf(format, args...))
+}
+
+// blockFrameLine is how a block goes in a BLOCK or BLOCKS frame.
+func blockFrameLine(height uint64, main bool, bl Block) string {
+	where := "side"
+	if main {
+		where = "main"
+	}
+	return fmt.Sprintf("%d %s %s", height, where, bl.ToString())
+}
+
+// ServeFramed answers framed commands on the connection until the client
+// sends QUIT, hangs up, or goes idle.  first is the line already read.
+func ServeFramed(connection net.Conn, bufReader *bufio.Reader, bc *BlockChain, first string) {
+	line := first
+	for {
+		resp, quit := HandleFramedCommand(bc, line)
+		_, err := connection.Write([]byte(resp))
+		if err != nil {
+			log.Printf("TCP error: %s\n", err.Error())
+			return
+		}
+		if quit {
+			return
+		}
+
+		connection.SetReadDeadline(time.Now().Add(protocolIdleTimeout))
+		line, err = bufReader.ReadString('\n')
+		if err != nil {
+			// hung up or timed out; either way, done
+			return
+		}
+	}
+}
+
+// HandleFramedCommand runs one command and returns the frame to send back,
+// and whether to hang up after.
+func HandleFramedCommand(bc *BlockChain, line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return errFrame(ErrCodeBadRequest, "empty command"), false
+	}
+	args := fields[1:]
+	n, ok := framedCommands[fields[0]]
+	if !ok {
+		return errFrame(ErrCodeBadRequest, "unknown command %q", fields[0]), false
+	}
+	if len(args) != n {
+		return errFrame(ErrCodeBadRequest, "%s takes %d arguments, got %d",
+			fields[0], n, len(args)), false
+	}
+
+	bc.mtx.Lock()
+	defer bc.mtx.Unlock()
+
+	switch fields[0] {
+	case "PING":
+		return okFrame("PONG"), false
+
+	case "QUIT":
+		return okFrame("BYE"), true
+
+	case "INFO":
+		return okFrame("INFO",
+			fmt.Sprintf("HEIGHT %d", bc.index.Height()),
+			fmt.Sprintf("TIP %x", bc.index.Tip().Hash()),
+			fmt.Sprintf("TARGET %s", TargetToString(bc.index.NextTarget())),
+			fmt.Sprintf("WORK %x", bc.index.TotalWork()),
+			fmt.Sprintf("BLOCKS %d", bc.index.Size()),
+		), false
+
+	case "GETBLOCK":
+		var h Hash
+		if len(args[0]) != 64 || strings.Trim(args[0], "0123456789abcdef") != "" {
+			return errFrame(ErrCodeBadRequest, "bad hash %q", args[0]), false
+		}
+		hex.Decode(h[:], []byte(args[0]))
+		bl, height, main, ok := bc.index.BlockByHash(h)
+		if !ok {
+			return errFrame(ErrCodeNotFound, "no block %s", args[0]), false
+		}
+		return okFrame("BLOCK", blockFrameLine(height, main, bl)), false
+
+	case "GETHEIGHT":
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return errFrame(ErrCodeBadRequest, "bad height %q", args[0]), false
+		}
+		bl, ok := bc.index.BlockAtHeight(height)
+		if !ok {
+			return errFrame(ErrCodeNotFound, "no block at height %d; chain height is %d",
+				height, bc.index.Height()), false
+		}
+		return okFrame("BLOCK", blockFrameLine(height, true, bl)), false
+
+	case "GETRANGE":
+		from, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return errFrame(ErrCodeBadRequest, "bad height %q", args[0]), false
+		}
+		count, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return errFrame(ErrCodeBadRequest, "bad count %q", args[1]), false
+		}
+		if count > maxRangeCount {
+			return errFrame(ErrCodeTooLarge, "count %d is over the max of %d",
+				count, maxRangeCount), false
+		}
+		var lines []string
+		for i, bl := range bc.index.MainChainRange(from, count) {
+			lines = append(lines, blockFrameLine(from+uint64(i), true, bl))
+		}
+		return okFrame("BLOCKS", lines...), false
+	}
+	// can't get here; every command in framedCommands is handled above
+	return errFrame(ErrCodeBadRequest, "unknown command %q", fields[0]), false
+}
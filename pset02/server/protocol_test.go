@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readFrame reads one response frame: the header line and the lines after.
+func readFrame(t *testing.T, r *bufio.Reader) (string, []string) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	header = strings.TrimSpace(header)
+	var typ string
+	var count int
+	if _, err := fmt.Sscanf(header, "OK %s %d", &typ, &count); err != nil {
+		return header, nil
+	}
+	lines := make([]string, count)
+	for i := range lines {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[i] = strings.TrimSpace(line)
+	}
+	return "OK " + typ, lines
+}
+
+// TestFramedProtocol runs a session of framed commands over one connection,
+// against a chain with a side branch.
+func TestFramedProtocol(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	bc := &BlockChain{index: NewBlockIndex(genesis)}
+	main := mineTestBranch(t, genesis.Hash(), "alice", 5)
+	side := mineTestBlock(t, main[1].Hash(), "bob")
+	for _, bl := range append(main, side) {
+		if _, err := bc.index.Add(bl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleServerConnection(server, bc)
+	r := bufio.NewReader(client)
+
+	send := func(cmd string) (string, []string) {
+		if _, err := fmt.Fprintf(client, "%s\n", cmd); err != nil {
+			t.Fatal(err)
+		}
+		return readFrame(t, r)
+	}
+
+	if typ, lines := send("PING"); typ != "OK PONG" || len(lines) != 0 {
+		t.Fatalf("PING: got %s %v", typ, lines)
+	}
+
+	typ, lines := send("INFO")
+	if typ != "OK INFO" || len(lines) != 5 || lines[0] != "HEIGHT 5" ||
+		lines[1] != "TIP "+main[4].Hash().ToString() || lines[4] != "BLOCKS 7" {
+		t.Fatalf("INFO: got %s %v", typ, lines)
+	}
+
+	typ, lines = send("GETHEIGHT 3")
+	if typ != "OK BLOCK" || len(lines) != 1 || lines[0] != "3 main "+main[2].ToString() {
+		t.Fatalf("GETHEIGHT: got %s %v", typ, lines)
+	}
+	typ, lines = send("GETBLOCK " + side.Hash().ToString())
+	if typ != "OK BLOCK" || len(lines) != 1 || lines[0] != "3 side "+side.ToString() {
+		t.Fatalf("GETBLOCK: got %s %v", typ, lines)
+	}
+
+	// sync the whole chain two at a time
+	var synced []string
+	for from := 1; ; from += 2 {
+		typ, lines = send(fmt.Sprintf("GETRANGE %d 2", from))
+		if typ != "OK BLOCKS" {
+			t.Fatalf("GETRANGE: got %s", typ)
+		}
+		if len(lines) == 0 {
+			break
+		}
+		synced = append(synced, lines...)
+	}
+	if len(synced) != 5 || synced[4] != "5 main "+main[4].ToString() {
+		t.Fatalf("synced %v", synced)
+	}
+
+	for _, e := range []struct{ cmd, code string }{
+		{"GETBLOCK " + strings.Repeat("ab", 32), "ERR 404"},
+		{"GETBLOCK xyz", "ERR 400"},
+		{"GETHEIGHT 6", "ERR 404"},
+		{"GETHEIGHT -1", "ERR 400"},
+		{"GETRANGE 1 100000", "ERR 413"},
+		{"GETRANGE 1", "ERR 400"},
+		{"FROB", "ERR 400"},
+	} {
+		if typ, _ := send(e.cmd); !strings.HasPrefix(typ, e.code+" ") {
+			t.Fatalf("%s: got %q, expect %s", e.cmd, typ, e.code)
+		}
+	}
+
+	if typ, _ := send("QUIT"); typ != "OK BYE" {
+		t.Fatalf("QUIT: got %s", typ)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Fatalf("connection still open after QUIT")
+	}
+}
+
+// TestLegacyTipRequest checks TRQ still gets its old one shot answer.
+func TestLegacyTipRequest(t *testing.T) {
+	useTestWork(t)
+	genesis, _ := BlockFromString(genesisBlock)
+	bc := &BlockChain{index: NewBlockIndex(genesis)}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleServerConnection(server, bc)
+	fmt.Fprintf(client, "TRQ\n")
+
+	r := bufio.NewReader(client)
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if len(lines) != 3 || lines[0] != genesisBlock || lines[2] != "HEIGHT 0" {
+		t.Fatalf("TRQ: got %v", lines)
+	}
+}
+
+// TestBlockSubmission sends blocks the old one shot way, and checks the
+// reply says where each one went, including the block that causes a reorg.
+func TestBlockSubmission(t *testing.T) {
+	useTestWork(t)
+	// the handler writes chain.txt in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err = os.WriteFile(chainFilename, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, _ := BlockFromString(genesisBlock)
+	bc := &BlockChain{index: NewBlockIndex(genesis), bchan: make(chan blockSubmission, 8)}
+	go HandleBlockSubmission(bc)
+
+	submit := func(bl Block) string {
+		client, server := net.Pipe()
+		defer client.Close()
+		go HandleServerConnection(server, bc)
+		fmt.Fprintf(client, "%s\n", bl.ToString())
+		line, _ := bufio.NewReader(client).ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	a := mineTestBranch(t, genesis.Hash(), "alice", 2)
+	b := mineTestBranch(t, genesis.Hash(), "bob", 3)
+	expect := []struct {
+		bl    Block
+		reply string
+	}{
+		{a[0], "Block accepted"},
+		{a[1], "Block accepted"},
+		{b[0], "Block accepted on a side branch at height 1"},
+		{b[1], "Block accepted on a side branch at height 2"},
+		{b[2], "Block accepted; reorg of depth 2, new tip at height 3"},
+		{b[2], "Block invalid: "},
+		// the old chain's names aren't allowed in new blocks
+		{Block{PrevHash: b[2].Hash(), Name: "zhejyan@microsoft.com", Nonce: "0"},
+			"Malformed block error: "},
+	}
+	for i, e := range expect {
+		if got := submit(e.bl); !strings.HasPrefix(got, e.reply) {
+			t.Fatalf("block %d: got %q, expect %q", i, got, e.reply)
+		}
+	}
+}
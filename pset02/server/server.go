@@ -2,8 +2,6 @@ package main
 
 import (
 	"bufio"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,75 +18,26 @@ var (
 	chainOldFilename = "./chainreload.txt"
 )
 
-// A hash is a sha256 hash, as in pset01
-type Hash [32]byte
-
-// ToString gives you a hex string of the hash
-func (self Hash) ToString() string {
-	return fmt.Sprintf("%x", self)
-}
-
-// Blocks are what make the chain in this pset; different than just a 32 byte array
-// from last time.  Has a previous block hash, a name and a nonce.
-type Block struct {
-	PrevHash Hash
-	Name     string
-	Nonce    string
+// BlockChain is the block index (see blockindex.go), plus the channel blocks
+// come in on.  The main chain is also written out to a file.
+type BlockChain struct {
+	mtx   sync.Mutex
+	index *BlockIndex
+	bchan chan blockSubmission
 }
 
-// ToString turns a block into an ascii string which can be sent over the
-// network or printed to the screen.
-func (self Block) ToString() string {
-	return fmt.Sprintf("%x %s %s", self.PrevHash, self.Name, self.Nonce)
+// A blockSubmission is a block for HandleBlockSubmission to add, and where to
+// send back what happened to it.  reply is nil if nobody's waiting, like when
+// loading the chain from disk.
+type blockSubmission struct {
+	block Block
+	reply chan<- blockResult
 }
 
-// Hash returns the sha256 hash of the block.  Hopefully starts with zeros!
-func (self Block) Hash() Hash {
-	return sha256.Sum256([]byte(self.ToString()))
-}
-
-// BlockFromString takes in a string and converts it to a block, if possible
-func BlockFromString(s string) (Block, error) {
-	var bl Block
-
-	// check string length
-	if len(s) < 66 || len(s) > 100 {
-		return bl, fmt.Errorf("Invalid string length %d, expect 66 to 100", len(s))
-	}
-	// split into 3 substrings via spaces
-	subStrings := strings.Split(s, " ")
-
-	if len(subStrings) != 3 {
-		return bl, fmt.Errorf("got %d elements, expect 3", len(subStrings))
-	}
-
-	hashbytes, err := hex.DecodeString(subStrings[0])
-	if err != nil {
-		return bl, err
-	}
-	if len(hashbytes) != 32 {
-		return bl, fmt.Errorf("got %d byte hash, expect 32", len(hashbytes))
-	}
-
-	copy(bl.PrevHash[:], hashbytes)
-
-	bl.Name = subStrings[1]
-
-	// remove trailing newline if there; the blocks don't include newlines, but
-	// when transmitted over TCP there's a newline to signal end of block
-	bl.Nonce = strings.TrimSpace(subStrings[2])
-
-	// TODO add more checks on name/nonce ...?
-
-	return bl, nil
-}
-
-// BlockChain is not actually a blockchain, it's just the tip.
-// The chain itself only exists in a file.
-type BlockChain struct {
-	mtx   sync.Mutex
-	tip   Block
-	bchan chan Block
+// blockResult is what the index said when a submitted block was added.
+type blockResult struct {
+	res AddResult
+	err error
 }
 
 func Server() error {
@@ -102,12 +51,12 @@ func Server() error {
 	var bc BlockChain
 
 	// initialize channel
-	bc.bchan = make(chan Block, 8)
+	bc.bchan = make(chan blockSubmission, 8)
 
 	// Ignore errors here; it's hard-coded
-	// this is kindof ugly as it doesn't show up anywhere so it's like height
-	// negative 1.  Weird but whatever.
-	bc.tip, _ = BlockFromString(genesisBlock)
+	// Genesis is height 0 in the index, but it's not in the chain file.
+	genesis, _ := BlockFromString(genesisBlock)
+	bc.index = NewBlockIndex(genesis)
 
 	// start handler routine for accepting new blocks from clients
 	go HandleBlockSubmission(&bc)
@@ -140,9 +89,6 @@ func Server() error {
 		go HandleServerConnection(serverConnection, &bc)
 
 	}
-
-	return nil
-
 }
 
 type Score struct {
@@ -174,16 +120,16 @@ func ServeHiScores(l net.Listener) {
 
 		// populate map with scores
 		for _, line := range lines {
-			// assume all block strings are good, so name is [1]
-			vals := strings.Split(line, " ")
-			if len(vals) < 3 {
+			// blocks can be legacy or versioned, so parse to find the name
+			bl, err := BlockFromString(line)
+			if err != nil {
 				continue
 			}
-			_, valid := scoreMap[vals[1]]
+			_, valid := scoreMap[bl.Name]
 			if !valid {
-				scoreMap[vals[1]] = 1
+				scoreMap[bl.Name] = 1
 			} else {
-				scoreMap[vals[1]]++
+				scoreMap[bl.Name]++
 			}
 		}
 
@@ -206,7 +152,7 @@ func ServeHiScores(l net.Listener) {
 		}
 
 		recentReply := fmt.Sprintf("--- most recent blocks ---\n")
-		for i := len(lines) - 10; i < len(lines); i++ {
+		for i := max(len(lines)-10, 0); i < len(lines); i++ {
 			recentReply += lines[i] + "\n"
 		}
 
@@ -238,16 +184,21 @@ func LoadChain(bc *BlockChain) error {
 			return err
 		}
 		// submit block to handler routine
-		bc.bchan <- newBl
+		bc.bchan <- blockSubmission{block: newBl}
 	}
 	return nil
 }
 
 /*
 Server protocol: listen for command.  Commands are "TRQ" or a block.
-Respond to TRQ with tip block.
-Respond to block with ACK message accepting block hash, or error.
-Respond to any other command with "Unknown command"
+Respond to TRQ with tip block, then a line "TARGET <64 hex>" with the target
+for the next block, and a line "HEIGHT <n>" with the height of the tip.  Old
+clients that only read one line still work.
+Respond to block with ACK message saying whether it extended the main chain,
+went on a side branch or caused a reorg, or error.
+Either way, hang up after.
+The framed commands (PING, INFO, GETBLOCK...) are in protocol.go; for those
+the connection stays open.
 */
 
 // Handle the connection from clients.  Concurrent goroutine, so there
@@ -264,6 +215,13 @@ func HandleServerConnection(connection net.Conn, bc *BlockChain) {
 		log.Printf("TCP error: %s\n", err.Error())
 	}
 
+	// framed commands get a session of their own
+	if isFramedCommand(string(blockLine)) {
+		ServeFramed(connection, bufReader, bc, string(blockLine))
+		connection.Close()
+		return
+	}
+
 	// sendBytes is whatever we're going to send them
 	var sendBytes []byte
 
@@ -272,31 +230,48 @@ func HandleServerConnection(connection net.Conn, bc *BlockChain) {
 		// ready tip for sending
 		// lock mutex, get the string to send, and unlock
 		bc.mtx.Lock()
-		sendString := bc.tip.ToString()
+		sendString := bc.index.Tip().ToString()
+		target := bc.index.NextTarget()
+		height := bc.index.Height()
 		bc.mtx.Unlock()
 
 		// use newline to indicate end of transmission.  A bit ugly but OK.
-		sendBytes = []byte(fmt.Sprintf("%s\n", sendString))
+		sendBytes = []byte(fmt.Sprintf("%s\nTARGET %s\nHEIGHT %d\n",
+			sendString, TargetToString(target), height))
 	} else {
 		// interpret as block submission
 		newBl, err := BlockFromString(string(blockLine))
+		if err == nil {
+			// new blocks are held to the charset even in the legacy format;
+			// only the chain loaded from disk is let off
+			err = newBl.ValidateSubmission()
+		}
 		if err != nil {
 			// neither TRQ nor block, send error message
 			sendBytes = []byte(fmt.Sprintf(
 				"Malformed block error: %s\n", err.Error()))
 		} else {
-			// no error, absorb block
-
-			// first check if it will append
-			if !CheckNextBlock(bc.tip, newBl) {
+			// no error, submit block to handler routine and wait to hear
+			// where it went
+			reply := make(chan blockResult, 1)
+			bc.bchan <- blockSubmission{block: newBl, reply: reply}
+			result := <-reply
+			switch {
+			case result.err != nil:
 				sendBytes = []byte(fmt.Sprintf(
-					"Block invalid: not enough work, or doesn't connect to tip\n"))
-			} else {
+					"Block invalid: %s\n", result.err.Error()))
+			case !result.res.MainChain:
+				// could still become the main chain if it gets built on
+				sendBytes = []byte(fmt.Sprintf(
+					"Block accepted on a side branch at height %d\n", result.res.Height))
+			case result.res.Reorg == 0:
 				sendBytes = []byte(fmt.Sprintf(
 					"Block accepted\n"))
+			default:
+				sendBytes = []byte(fmt.Sprintf(
+					"Block accepted; reorg of depth %d, new tip at height %d\n",
+					result.res.Reorg, result.res.Height))
 			}
-			// submit block to handler routine
-			bc.bchan <- newBl
 		}
 	}
 
@@ -311,84 +286,79 @@ func HandleServerConnection(connection net.Conn, bc *BlockChain) {
 	return
 }
 
-// HandleBlockSubmission checks that the block is OK and fits on the end of the
-// chain, then adds it on at the end.
+// HandleBlockSubmission checks that the block is OK and adds it to the index.
+// If it extends the main chain it's appended to the chain file; if it causes
+// a reorg the chain file is rewritten with the new main chain.  What happened
+// goes back on the submission's reply channel, if it has one.
 func HandleBlockSubmission(bc *BlockChain) {
-	var blockHistory []Block
-
 	// loop forever
 	for {
-		proposedBlock := <-bc.bchan
+		sub := <-bc.bchan
+		proposedBlock := sub.block
 		log.Printf("got hash %x\n", proposedBlock.Hash())
 
-		// TODO actually check stuff
-
-		// Pretty sure I don't need a mutex here because I'm just reading
-		// and this function is the only thing that can write.  Also there's
-		// only 1 iteration of this loop happening at a time.
-		if !CheckNextBlock(bc.tip, proposedBlock) {
-			log.Printf("Invalid block received:\n%s\n", proposedBlock.ToString())
+		// only this goroutine changes the index, but connection handlers read
+		// it, so hold the mutex the whole time
+		bc.mtx.Lock()
+		res, err := bc.index.Add(proposedBlock)
+		if sub.reply != nil {
+			sub.reply <- blockResult{res: res, err: err}
+		}
+		if err != nil {
+			bc.mtx.Unlock()
+			log.Printf("Invalid block received: %s\n%s\n",
+				err.Error(), proposedBlock.ToString())
 			continue
 		}
 
-		// this thing keeps history, maybe save to disk...
-		blockHistory = append(blockHistory, proposedBlock)
-
-		// use mutex and update tip
-		// also append to file
-		bc.mtx.Lock()
-		bc.tip = proposedBlock
+		switch {
+		case !res.MainChain:
+			log.Printf("Block on side branch at height %d; main chain height is %d\n",
+				res.Height, bc.index.Height())
+		case res.Reorg == 0:
+			err = appendChainFile(proposedBlock)
+			log.Printf("Block accepted; height is now %d\n", res.Height)
+		default:
+			err = writeChainFile(bc.index.MainChain())
+			log.Printf("REORG: depth %d, old tip %x, new tip %x at height %d\n",
+				res.Reorg, res.OldTip, proposedBlock.Hash(), res.Height)
+		}
+		bc.mtx.Unlock()
 
-		f, err := os.OpenFile(chainFilename, os.O_APPEND|os.O_WRONLY, 0666)
 		if err != nil {
 			// crash if file doesn't work
 			panic(err)
 		}
-
-		f.WriteString(proposedBlock.ToString() + "\n")
-		f.Close()
-
-		bc.mtx.Unlock()
-
-		log.Printf("Block accepted; height is now %d\n", len(blockHistory))
-
 	}
 }
 
-// CheckNextBlock checks if the block attaches, has work, etc.
-// Assumes "prev" block is OK, but checks "next"
-func CheckNextBlock(prev, next Block) bool {
-	// first check the work on the new block.  33 bits needed.
-	if !CheckWork(next, 33) {
-		log.Printf("not enought work! ")
-		return false
+// appendChainFile adds one block to the end of the chain file.
+func appendChainFile(bl Block) error {
+	f, err := os.OpenFile(chainFilename, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
 	}
-
-	// first, check that next points back to prev
-	if prev.Hash() != next.PrevHash {
-		log.Printf("hashes don't chain up! ")
-		return false
+	_, err = f.WriteString(bl.ToString() + "\n")
+	if err != nil {
+		f.Close()
+		return err
 	}
-
-	// probably enough checks for now?
-
-	return true
+	return f.Close()
 }
 
-// CheckWork checks if there's enough work
-func CheckWork(bl Block, targetBits uint8) bool {
-	h := bl.Hash()
-
-	for i := uint8(0); i < targetBits; i++ {
-		// for every bit from the MSB down, check if it's a 1.
-		// If it is, stop and fail.
-		// Could definitely speed this up by checking bytes at a time.
-		// Left as excercise for the reader...?
-		if (h[i/8]>>(7-(i%8)))&0x01 == 1 {
-			return false
-		}
+// writeChainFile replaces the chain file with the given blocks.  Writes to a
+// temp file and renames it, so the hi score server never sees half a chain.
+func writeChainFile(blocks []Block) error {
+	var sb strings.Builder
+	for _, bl := range blocks {
+		sb.WriteString(bl.ToString() + "\n")
+	}
+	tmpFilename := chainFilename + ".tmp"
+	err := ioutil.WriteFile(tmpFilename, []byte(sb.String()), 0666)
+	if err != nil {
+		return err
 	}
-	return true
+	return os.Rename(tmpFilename, chainFilename)
 }
 
 func main() {